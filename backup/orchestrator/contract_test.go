@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"calculator/internal"
+	"calculator/internal/agentauth"
+	"calculator/internal/api"
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"calculator/internal/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+const contractAgentToken = "contract-agent-token"
+
+// contractClient выполняет запросы к серверу и сверяет каждый ответ
+// с документом OpenAPI по шаблону маршрута, который его обработал
+type contractClient struct {
+	t      *testing.T
+	srv    *httptest.Server
+	router *mux.Router
+	doc    *openapi.Document
+	// checked — проверенные пары "METHOD шаблон"
+	checked map[string]bool
+	// lastETag — ETag последнего ответа
+	lastETag string
+}
+
+func newContractClient(t *testing.T) (*contractClient, *sql.DB) {
+	db, err := internal.OpenDB(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := agentauth.NewTokens(map[string]string{"agent-1": contractAgentToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("документ OpenAPI не разбирается: %v", err)
+	}
+	router := api.NewRouter(db, api.NewHandler(db), tokens)
+	srv := httptest.NewServer(corsMiddleware(apierror.Middleware(router)))
+	t.Cleanup(srv.Close)
+	return &contractClient{t: t, srv: srv, router: router, doc: doc, checked: map[string]bool{}}, db
+}
+
+// do выполняет запрос и возвращает код и тело. header — пары имя, значение.
+func (c *contractClient) do(method, path, token string, body interface{}, header ...string) (int, []byte) {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var match mux.RouteMatch
+	if !c.router.Match(req, &match) || match.Route == nil {
+		c.t.Fatalf("%s %s: маршрут не найден", method, path)
+	}
+	tmpl, _ := match.Route.GetPathTemplate()
+	if err := c.doc.ValidateResponse(method, tmpl, resp.StatusCode, resp.Header.Get("Content-Type"), data); err != nil {
+		c.t.Errorf("ответ не соответствует документу: %v", err)
+	}
+	c.checked[method+" "+tmpl] = true
+	c.lastETag = resp.Header.Get("ETag")
+	return resp.StatusCode, data
+}
+
+// expect — do с проверкой кода ответа
+func (c *contractClient) expect(status int, method, path, token string, body interface{}, header ...string) []byte {
+	c.t.Helper()
+	code, data := c.do(method, path, token, body, header...)
+	if code != status {
+		c.t.Fatalf("%s %s: код %d, ожидался %d, тело %s", method, path, code, status, data)
+	}
+	return data
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("разбор ответа %s: %v", data, err)
+	}
+}
+
+// TestRoutesDocumented — каждый маршрут роутера описан в документе и наоборот
+func TestRoutesDocumented(t *testing.T) {
+	c, _ := newContractClient(t)
+	served := map[string]bool{}
+	c.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || tmpl == "/" {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // префикс подроутера
+		}
+		for _, m := range methods {
+			if m == "OPTIONS" {
+				continue
+			}
+			served[m+" "+tmpl] = true
+			if _, ok := c.doc.Operation(m, tmpl); !ok {
+				t.Errorf("%s %s не описан в документе", m, tmpl)
+			}
+		}
+		return nil
+	})
+	for path, methods := range c.doc.Paths {
+		for m := range methods {
+			if key := strings.ToUpper(m) + " " + path; !served[key] {
+				t.Errorf("%s описан, но не обслуживается", key)
+			}
+		}
+	}
+}
+
+// TestContract проходит основные сценарии API и сверяет все ответы со схемой
+func TestContract(t *testing.T) {
+	c, db := newContractClient(t)
+	ivan := map[string]string{"login": "ivan", "password": "ivan's long password"}
+	petr := map[string]string{"login": "petr", "password": "petr's long password"}
+
+	// Описание API и документация
+	c.expect(http.StatusOK, "GET", "/api/openapi.json", "", nil)
+	c.expect(http.StatusOK, "GET", "/api/docs", "", nil)
+	c.expect(http.StatusOK, "GET", "/.well-known/jwks.json", "", nil)
+
+	// Регистрация, вход, токены
+	c.expect(http.StatusOK, "POST", "/api/v1/register", "", ivan)
+	c.expect(http.StatusOK, "POST", "/api/v1/register", "", petr)
+	c.expect(http.StatusConflict, "POST", "/api/v1/register", "", ivan)
+	c.expect(http.StatusBadRequest, "POST", "/api/v1/register", "", map[string]string{"login": "anna", "password": "short"}, "Accept-Language", "en")
+	c.expect(http.StatusUnauthorized, "POST", "/api/v1/login", "", map[string]string{"login": "ivan", "password": "wrong password"})
+	var pair struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(t, c.expect(http.StatusOK, "POST", "/api/v1/login", "", ivan), &pair)
+	decode(t, c.expect(http.StatusOK, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken}), &pair)
+	token := pair.Token
+	var other struct {
+		Token string `json:"token"`
+	}
+	decode(t, c.expect(http.StatusOK, "POST", "/api/v1/login", "", petr), &other)
+
+	// Аккаунт
+	c.expect(http.StatusUnauthorized, "GET", "/api/v1/me", "", nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/me", token, nil)
+	c.expect(http.StatusOK, "PUT", "/api/v1/me/login", other.Token, map[string]string{"login": "pyotr", "current_password": petr["password"]})
+	decode(t, c.expect(http.StatusOK, "PUT", "/api/v1/me/password", other.Token, map[string]string{
+		"current_password": petr["password"], "new_password": "petr's new long password"}), &other)
+
+	// API ключи
+	var key struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/api-keys", token, map[string]interface{}{
+		"name": "ci", "scopes": []string{"read"}, "expires_in": "720h"}), &key)
+	c.expect(http.StatusOK, "GET", "/api/v1/api-keys", token, nil)
+	c.expect(http.StatusNoContent, "DELETE", "/api/v1/api-keys/"+key.ID, token, nil)
+
+	// Вебхуки
+	var hook struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/webhooks", token, map[string]interface{}{
+		"url": "https://example.com/hook", "events": []string{"expression.completed"}}), &hook)
+	c.expect(http.StatusOK, "GET", "/api/v1/webhooks", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries?status=dead", token, nil)
+	c.expect(http.StatusNotFound, "POST", "/api/v1/webhooks/"+hook.ID+"/deliveries/missing/redeliver", token, nil)
+	c.expect(http.StatusNoContent, "DELETE", "/api/v1/webhooks/"+hook.ID, token, nil)
+
+	// Выражения
+	var created struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/calculate", token, map[string]interface{}{
+		"expression": "(a+2)*3", "variables": map[string]float64{"a": 1}}, "Idempotency-Key", "order-1"), &created)
+	c.expect(http.StatusCreated, "POST", "/api/v1/calculate", token, map[string]interface{}{
+		"expression": "(a+2)*3", "variables": map[string]float64{"a": 1}}, "Idempotency-Key", "order-1")
+	c.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/calculate", token, map[string]string{"expression": "2*(3"})
+	c.expect(http.StatusOK, "POST", "/api/v1/evaluate", token, map[string]interface{}{"expression": "2+2*2"})
+	c.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/evaluate", token, map[string]interface{}{"expression": "1/0", "persist": true})
+	c.expect(http.StatusAccepted, "POST", "/api/v1/evaluate", token, map[string]interface{}{"expression": "5-1", "mode": "distributed", "timeout": "1ms"})
+	c.expect(http.StatusOK, "POST", "/api/v1/expressions/plan", token, map[string]string{"expression": "(1+2)*(3-4)"})
+	var batch struct {
+		BatchID string `json:"batch_id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/calculate/batch", token, map[string]interface{}{
+		"items": []map[string]string{{"expression": "7", "ref": "seven"}, {"expression": "2+"}}}), &batch)
+	c.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/calculate/batch", token, map[string]interface{}{
+		"items": []map[string]string{{"expression": "2+"}}})
+	c.expect(http.StatusOK, "GET", "/api/v1/batches/"+batch.BatchID, token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions?limit=2&sort=created_at&order=desc", token, nil)
+	c.expect(http.StatusBadRequest, "GET", "/api/v1/expressions?limit=0", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/"+created.ID, token, nil)
+	c.expect(http.StatusNotFound, "GET", "/api/v1/expressions/missing", token, nil)
+
+	// Заметки к выражениям и поиск
+	var noted struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/calculate", token, map[string]interface{}{
+		"expression": "3*4", "title": "Площадь комнаты", "tags": []string{"Дом"}}), &noted)
+	c.expect(http.StatusOK, "PATCH", "/api/v1/expressions/"+noted.ID, token, map[string]interface{}{
+		"notes": "без учета балкона", "tags": []string{"дом", "ремонт"}})
+	c.expect(http.StatusBadRequest, "PATCH", "/api/v1/expressions/"+noted.ID, token, map[string]string{"status": "completed"})
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/search?q=площадь&tag=ремонт", token, nil)
+	c.expect(http.StatusBadRequest, "GET", "/api/v1/expressions/search", token, nil)
+
+	// Агент забирает задачу и возвращает результат
+	agent := []string{agentauth.HeaderName, contractAgentToken}
+	c.expect(http.StatusUnauthorized, "GET", "/internal/task", "", nil)
+	var task struct {
+		Task struct {
+			ID string `json:"id"`
+		} `json:"task"`
+	}
+	decode(t, c.expect(http.StatusOK, "GET", "/internal/task", "", nil, agent...), &task)
+	c.expect(http.StatusOK, "POST", "/internal/task", "", map[string]interface{}{"id": task.Task.ID, "result": 3}, agent...)
+	c.expect(http.StatusConflict, "POST", "/internal/task", "", map[string]interface{}{"id": task.Task.ID, "result": 3}, agent...)
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/"+created.ID+"/timeline", token, nil)
+
+	// API v2 над теми же выражениями
+	var calc struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v2/calculations", token, map[string]interface{}{
+		"expression": "(x-1)/2", "variables": map[string]float64{"x": 5}}), &calc)
+	c.expect(http.StatusUnprocessableEntity, "POST", "/api/v2/calculations", token, map[string]string{"expression": "1+"})
+	c.expect(http.StatusOK, "GET", "/api/v2/calculations?limit=2", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v2/calculations/"+calc.ID, token, nil)
+	c.expect(http.StatusOK, "PATCH", "/api/v2/calculations/"+calc.ID, token, map[string]string{"ref": "invoice-7"})
+	c.expect(http.StatusPreconditionFailed, "PATCH", "/api/v2/calculations/"+calc.ID, token, map[string]string{"ref": "x"}, "If-Match", `"stale"`)
+	c.expect(http.StatusOK, "GET", "/api/v2/calculations/"+created.ID+"/tasks", token, nil)
+	c.expect(http.StatusNotModified, "GET", "/api/v2/calculations/"+created.ID+"/tasks", token, nil,
+		"If-None-Match", c.lastETag)
+
+	// Совместный доступ
+	c.expect(http.StatusCreated, "POST", "/api/v1/expressions/"+created.ID+"/grants", token, map[string]string{"login": "pyotr"})
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/"+created.ID+"/grants", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/shared-with-me", other.Token, nil)
+	c.expect(http.StatusNoContent, "DELETE", "/api/v1/expressions/"+created.ID+"/grants/pyotr", token, nil)
+	var link struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	decode(t, c.expect(http.StatusCreated, "POST", "/api/v1/expressions/"+created.ID+"/links", token, map[string]string{"expires_in": "1h"}), &link)
+	c.expect(http.StatusOK, "GET", "/api/v1/expressions/"+created.ID+"/links", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/shared/"+link.Token, "", nil)
+	c.expect(http.StatusNoContent, "DELETE", "/api/v1/expressions/"+created.ID+"/links/"+link.ID, token, nil)
+	c.expect(http.StatusNotFound, "GET", "/api/v1/shared/"+link.Token, "", nil)
+
+	// Администрирование
+	c.expect(http.StatusForbidden, "GET", "/api/v1/admin/users", token, nil)
+	if err := api.EnsureAdmins(db, []string{"ivan"}); err != nil {
+		t.Fatal(err)
+	}
+	decode(t, c.expect(http.StatusOK, "POST", "/api/v1/login", "", ivan), &pair)
+	token = pair.Token
+	var users struct {
+		Users []struct {
+			ID    string `json:"id"`
+			Login string `json:"login"`
+		} `json:"users"`
+	}
+	decode(t, c.expect(http.StatusOK, "GET", "/api/v1/admin/users", token, nil), &users)
+	for _, u := range users.Users {
+		if u.Login == "pyotr" {
+			c.expect(http.StatusOK, "PATCH", "/api/v1/admin/users/"+u.ID, token, map[string]bool{"disabled": true})
+		}
+	}
+	c.expect(http.StatusOK, "GET", "/api/v1/admin/expressions", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/admin/queue", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/admin/agents", token, nil)
+	c.expect(http.StatusOK, "GET", "/api/v1/admin/operation-costs", token, nil)
+	c.expect(http.StatusOK, "PUT", "/api/v1/admin/operation-costs", token, map[string]int64{"+": 10})
+
+	// Выход и удаление аккаунта
+	c.expect(http.StatusNoContent, "POST", "/api/v1/logout", token, map[string]string{"refresh_token": pair.RefreshToken})
+	decode(t, c.expect(http.StatusOK, "POST", "/api/v1/login", "", ivan), &pair)
+	c.expect(http.StatusNoContent, "DELETE", "/api/v1/me", pair.Token, map[string]string{"current_password": ivan["password"]})
+
+	// Потоковые /events и /ws проверяются своими тестами; остальное должно быть пройдено
+	var missed []string
+	for path, methods := range c.doc.Paths {
+		for m := range methods {
+			key := strings.ToUpper(m) + " " + path
+			if !c.checked[key] && path != "/api/v1/events" && path != "/api/v1/ws" {
+				missed = append(missed, key)
+			}
+		}
+	}
+	sort.Strings(missed)
+	if len(missed) > 0 {
+		t.Errorf("сценарий не проверяет: %v", missed)
+	}
+}
+
+// TestValidateResponseRejectsDrift — проверка ловит лишние и пропавшие поля
+func TestValidateResponseRejectsDrift(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{
+		`{"id":"a","extra":1}`, // поле, которого нет в документе
+		`{}`,                   // нет обязательного id
+		`{"id":1}`,             // неверный тип
+	} {
+		if doc.ValidateResponse("POST", "/api/v1/calculate", http.StatusCreated, "application/json", []byte(body)) == nil {
+			t.Errorf("%s: ответ принят", body)
+		}
+	}
+	if err := doc.ValidateResponse("POST", "/api/v1/calculate", http.StatusCreated, "application/json", []byte(`{"id":"a"}`)); err != nil {
+		t.Errorf("корректный ответ: %v", err)
+	}
+	if doc.ValidateResponse("GET", "/api/v1/unknown", http.StatusOK, "application/json", nil) == nil {
+		t.Error("неописанный путь принят")
+	}
+}
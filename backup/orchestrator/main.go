@@ -2,16 +2,22 @@ package main
 
 import (
 	"calculator/internal"
+	"calculator/internal/agentauth"
 	"calculator/internal/api"
+	"calculator/internal/apierror"
+	"calculator/internal/jwtkeys"
 	"calculator/internal/models"
+	"calculator/internal/tlsconfig"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-
-	"github.com/gorilla/mux"
+	"time"
 )
 
 var (
@@ -56,6 +62,53 @@ func init() {
 
 	// Получаем порт из переменной файла настроек
 	port = getEnv("ORCHESTRATOR_PORT", "8080")
+
+	// Время жизни access и refresh токенов
+	if ttl, err := time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "15m")); err == nil {
+		api.AccessTokenTTL = ttl
+	}
+	if ttl, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h")); err == nil {
+		api.RefreshTokenTTL = ttl
+	}
+
+	// Политика паролей и блокировка подбора
+	if n, err := strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "8")); err == nil {
+		api.Policy.MinLength = n
+	}
+	if n, err := strconv.Atoi(getEnv("PASSWORD_MAX_LENGTH", "128")); err == nil {
+		api.Policy.MaxLength = n
+	}
+	if path := getEnv("PASSWORD_DENYLIST_FILE", ""); path != "" {
+		if err := api.Policy.LoadDenylist(path); err != nil {
+			log.Printf("Не удалось загрузить список запрещенных паролей: %v", err)
+		}
+	}
+	if n, err := strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5")); err == nil {
+		api.LoginThrottle.MaxFailures = n
+	}
+	if n, err := strconv.Atoi(getEnv("LOGIN_MAX_IP_FAILURES", "50")); err == nil {
+		api.LoginThrottle.MaxIPFailures = n
+	}
+	if d, err := time.ParseDuration(getEnv("LOGIN_LOCKOUT_BASE", "30s")); err == nil {
+		api.LoginThrottle.BaseLockout = d
+	}
+	if d, err := time.ParseDuration(getEnv("LOGIN_LOCKOUT_MAX", "1h")); err == nil {
+		api.LoginThrottle.MaxLockout = d
+	}
+	if d, err := time.ParseDuration(getEnv("LOGIN_FAILURE_WINDOW", "15m")); err == nil {
+		api.LoginThrottle.FailureWindow = d
+	}
+
+	// Сколько хранятся ключи идемпотентности POST /api/v1/calculate
+	if d, err := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h")); err == nil {
+		api.IdempotencyTTL = d
+	}
+	if d, err := time.ParseDuration(getEnv("TASK_LEASE_TIMEOUT", "5m")); err == nil && d > 0 {
+		api.TaskLeaseTimeout = d
+	}
+	if ok, err := strconv.ParseBool(getEnv("WEBHOOK_ALLOW_PRIVATE", "false")); err == nil {
+		api.WebhookAllowPrivate = ok
+	}
 }
 
 // добавим поддержку CORS чтобы браузер мог обращаться к оркестратору
@@ -72,8 +125,9 @@ func corsMiddleware(next http.Handler) http.Handler {
 		if strings.HasPrefix(r.URL.Path, "/api/") {
 
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key, Accept-Language, X-Request-ID, If-Match, If-None-Match")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, X-Request-ID, Retry-After")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -84,7 +138,99 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// genCerts — подкоманда gen-certs: локальный CA и сертификаты для тестовых окружений
+func genCerts(args []string) {
+	fs := flag.NewFlagSet("gen-certs", flag.ExitOnError)
+	dir := fs.String("dir", "certs", "каталог для сертификатов")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "имена и IP адреса оркестратора через запятую")
+	agents := fs.String("agents", "agent-1", "имена агентов через запятую")
+	days := fs.Int("days", 365, "срок действия сертификатов, дней")
+	fs.Parse(args)
+
+	agentNames := splitList(*agents)
+	files, err := tlsconfig.GenerateDevCerts(tlsconfig.DevCertsOptions{
+		Dir:      *dir,
+		Hosts:    splitList(*hosts),
+		Agents:   agentNames,
+		Validity: time.Duration(*days) * 24 * time.Hour,
+	})
+	if err != nil {
+		log.Fatalf("Ошибка генерации сертификатов: %v", err)
+	}
+	fmt.Printf("Оркестратор:\n  TLS_CERT_FILE=%s\n  TLS_KEY_FILE=%s\n  AGENT_CA_FILE=%s\n", files.Server, files.ServerKey, files.CA)
+	for _, name := range agentNames {
+		f := files.Agents[name]
+		fmt.Printf("Агент %s:\n  ORCHESTRATOR_CA_FILE=%s\n  AGENT_CERT_FILE=%s\n  AGENT_KEY_FILE=%s\n", name, files.CA, f[0], f[1])
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadTLS читает настройки TLS для HTTP API и gRPC канала агентов.
+// Сертификат gRPC по умолчанию тот же, что и у HTTP API; AGENT_CA_FILE
+// включает проверку клиентских сертификатов агентов (mTLS): на gRPC
+// сертификат обязателен, на HTTP — нет, там его предъявляют только агенты.
+func loadTLS() (httpTLS, grpcTLS *tls.Config, err error) {
+	minVersion := getEnv("TLS_MIN_VERSION", "1.2")
+	httpCfg := tlsconfig.ServerConfig{
+		CertFile:           os.Getenv("TLS_CERT_FILE"),
+		KeyFile:            os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile:       os.Getenv("AGENT_CA_FILE"),
+		ClientCertOptional: true,
+		MinVersion:         minVersion,
+	}
+	grpcCfg := tlsconfig.ServerConfig{
+		CertFile:     getEnv("GRPC_TLS_CERT_FILE", httpCfg.CertFile),
+		KeyFile:      getEnv("GRPC_TLS_KEY_FILE", httpCfg.KeyFile),
+		ClientCAFile: os.Getenv("AGENT_CA_FILE"),
+		MinVersion:   minVersion,
+	}
+	if httpCfg.Enabled() {
+		if httpTLS, err = tlsconfig.Server(httpCfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	if grpcCfg.Enabled() {
+		if grpcTLS, err = tlsconfig.Server(grpcCfg); err != nil {
+			return nil, nil, err
+		}
+	} else if grpcCfg.ClientCAFile != "" {
+		return nil, nil, fmt.Errorf("AGENT_CA_FILE требует сертификат сервера (TLS_CERT_FILE или GRPC_TLS_CERT_FILE)")
+	}
+	return httpTLS, grpcTLS, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen-certs" {
+		genCerts(os.Args[2:])
+		return
+	}
+
+	// Ключи JWT загружаются до открытия БД: в production без секрета стартовать нельзя
+	keys, err := jwtkeys.Load(jwtkeys.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключей JWT: %v", err)
+	}
+	api.SetKeys(keys)
+
+	// Токены агентов: без них gRPC и /internal/task недоступны
+	agentTokens, err := agentauth.Load(agentauth.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Ошибка загрузки токенов агентов: %v", err)
+	}
+
+	httpTLS, grpcTLS, err := loadTLS()
+	if err != nil {
+		log.Fatalf("Ошибка настройки TLS: %v", err)
+	}
 
 	db, err := internal.OpenDB("arifmethic.db")
 	if err != nil {
@@ -95,8 +241,25 @@ func main() {
 		log.Fatalf("Ошибка миграции БД: %v", err)
 	}
 
-	r := mux.NewRouter()
+	if admins := getEnv("ADMIN_LOGINS", ""); admins != "" {
+		if err := api.EnsureAdmins(db, strings.Split(admins, ",")); err != nil {
+			log.Fatalf("Ошибка назначения администраторов: %v", err)
+		}
+	}
+
+	// Время выполнения операций из окружения; значения, измененные
+	// через admin API, хранятся в БД и имеют приоритет
+	api.DefaultOperationTimes["+"] = timeAdditionMS
+	api.DefaultOperationTimes["-"] = timeSubtractionMS
+	api.DefaultOperationTimes["*"] = timeMultiplicationMS
+	api.DefaultOperationTimes["/"] = timeDivisionMS
+
 	handler := api.NewHandler(db)
+	if models.FullTextSearch(db) {
+		log.Printf("Поиск по выражениям: полнотекстовый индекс FTS5")
+	} else {
+		log.Printf("Поиск по выражениям: подстрока через LIKE (сборка без тега sqlite_fts5)")
+	}
 
 	// Запуск gRPC сервера для агентов на другом порту, чтобы избежать конфликта
 	grpcPort := "8082" // Используем порт 8082 для gRPC
@@ -104,36 +267,35 @@ func main() {
 		handler.GetTaskForAgent,   // функция получения задачи
 		handler.SubmitAgentResult, // функция отправки результата
 		grpcPort,                  // порт для gRPC сервера
+		agentTokens,               // токены агентов
+		grpcTLS,                   // TLS и mTLS канала агентов
 	)
 	log.Printf("Запускаем gRPC сервер на порту %s", grpcPort)
 
-	// Регистрация и логин
-	r.HandleFunc("/api/v1/register", api.RegisterHandler(db)).Methods("POST")
-	r.HandleFunc("/api/v1/login", api.LoginHandler(db)).Methods("POST")
-
-	// Публичные API endpoints (требуют JWT)
-	r.Handle("/api/v1/calculate", api.JWTMiddleware(http.HandlerFunc(handler.CalculateHandler))).Methods("POST", "OPTIONS")
-	r.Handle("/api/v1/expressions", api.JWTMiddleware(http.HandlerFunc(handler.GetExpressionsHandler))).Methods("GET", "OPTIONS")
-	r.Handle("/api/v1/expressions/{id}", api.JWTMiddleware(http.HandlerFunc(handler.GetExpressionHandler))).Methods("GET", "OPTIONS")
+	// Доставка вебхуков в фоне
+	go handler.RunWebhookDispatcher(context.Background())
+	// Задачи упавших агентов возвращаются в очередь
+	go handler.RunLeaseReaper(context.Background())
 
-	// Внутренние API endpoints для агентов
-	r.HandleFunc("/internal/task", handler.GetTaskHandler).Methods("GET")
-	r.HandleFunc("/internal/task", handler.SubmitTaskResultHandler).Methods("POST")
+	r := api.NewRouter(db, handler, agentTokens)
 
-	// Обработчик для калькулятора на корневом пути
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "calculator.html")
-	}).Methods("GET")
-
-	// Применяем CORS middleware ко всем маршрутам
-	corsRouter := corsMiddleware(r)
+	// Применяем CORS middleware ко всем маршрутам; X-Request-ID попадает
+	// в ответ и в тело ошибок
+	corsRouter := corsMiddleware(apierror.Middleware(r))
 
 	// Формируем адрес для прослушивания, HTTP сервер на порту 8081, поскольку gRPC уже использует порт 8080
 	httpPort := "8081"
 	listenAddr := fmt.Sprintf(":%s", httpPort)
 	log.Printf("Запускаем HTTP сервер оркестратора на порту %s", listenAddr)
 
-	if err := http.ListenAndServe(listenAddr, corsRouter); err != nil {
+	server := &http.Server{Addr: listenAddr, Handler: corsRouter, TLSConfig: httpTLS}
+	if httpTLS != nil {
+		// Сертификат уже загружен в TLSConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"calculator/internal"
+	"calculator/internal/agentauth"
+	"calculator/internal/tlsconfig"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
@@ -36,7 +39,30 @@ func main() {
 	orchestratorHost := getEnv("ORCHESTRATOR_HOST", "localhost")
 	grpcAddr := fmt.Sprintf("%s:%s", orchestratorHost, orchestratorPort)
 
-	client, err := internal.NewAgentGRPCClient(grpcAddr)
+	// Токен, выданный этому агенту в AGENT_TOKENS оркестратора
+	agentToken := os.Getenv("AGENT_TOKEN")
+	if agentToken == "" {
+		log.Printf("ВНИМАНИЕ: AGENT_TOKEN не задан, используется токен для разработки")
+		agentToken = agentauth.DevToken
+	}
+
+	// TLS включается, если задан CA оркестратора или клиентский сертификат (mTLS)
+	var tlsConf *tls.Config
+	tlsClient := tlsconfig.ClientConfig{
+		CAFile:     os.Getenv("ORCHESTRATOR_CA_FILE"),
+		CertFile:   os.Getenv("AGENT_CERT_FILE"),
+		KeyFile:    os.Getenv("AGENT_KEY_FILE"),
+		ServerName: os.Getenv("ORCHESTRATOR_SERVER_NAME"),
+		MinVersion: os.Getenv("TLS_MIN_VERSION"),
+	}
+	if tlsClient.CAFile != "" || tlsClient.CertFile != "" || getEnv("ORCHESTRATOR_TLS", "false") == "true" {
+		tlsConf, err = tlsconfig.Client(tlsClient)
+		if err != nil {
+			log.Fatalf("Ошибка настройки TLS: %v", err)
+		}
+	}
+
+	client, err := internal.NewAgentGRPCClient(grpcAddr, agentToken, tlsConf)
 	if err != nil {
 		log.Fatalf("Ошибка подключения к gRPC серверу оркестратора: %v", err)
 	}
@@ -101,8 +127,8 @@ func worker(id int, wg *sync.WaitGroup, client *internal.AgentGRPCClient) {
 			log.Printf("Вычислитель %d: %f * %f = %f", id, arg1, arg2, result)
 		case "/":
 			if arg2 == 0 {
+				// Отправляем Inf/NaN: оркестратор завершит выражение с ошибкой
 				log.Printf("Вычислитель %d: Деление на ноль!", id)
-				continue
 			}
 			result = arg1 / arg2
 			log.Printf("Вычислитель %d: %f / %f = %f", id, arg1, arg2, result)
@@ -12,6 +12,7 @@ import (
 
 	"calculator/internal/auth"
 	"calculator/internal/calculatorpb"
+	"calculator/internal/jwtkeys"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
@@ -31,6 +32,12 @@ type Expression struct {
 func main() {
 	log.Println("Запуск упрощенного оркестратора с авторизацией")
 
+	keys, err := jwtkeys.Load(jwtkeys.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключей JWT: %v", err)
+	}
+	auth.SetKeys(keys)
+
 	// Создаем подключение к базе данных SQLite
 	db, err := sql.Open("sqlite3", "./simple.db")
 	if err != nil {
@@ -0,0 +1,234 @@
+// Package agentauth — аутентификация агентов перед оркестратором.
+//
+// Каждый агент получает имя и заранее выданный токен. Агент передает
+// токен в метаданных gRPC ("authorization: Bearer <токен>") или в
+// заголовке X-Agent-Token для HTTP API /internal/task; оркестратор
+// находит по токену имя агента и записывает его в контекст запроса.
+// При mTLS вместо токена можно предъявить клиентский сертификат,
+// подписанный CA агентов: именем агента считается CommonName.
+package agentauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"calculator/internal/apierror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DevToken — токен агента для локальной разработки. В production запрещен.
+const DevToken = "dev-agent-token-change-me-in-production"
+
+// MinTokenLength — минимальная длина токена агента в production
+const MinTokenLength = 32
+
+// HeaderName — HTTP заголовок с токеном агента
+const HeaderName = "X-Agent-Token"
+
+// Tokens сопоставляет токены агентов с их именами
+type Tokens struct {
+	names  []string
+	hashes [][sha256.Size]byte
+}
+
+// NewTokens строит набор из пар имя → токен
+func NewTokens(byName map[string]string) (*Tokens, error) {
+	t := &Tokens{}
+	for name, token := range byName {
+		if name == "" || token == "" {
+			return nil, errors.New("agentauth: empty agent name or token")
+		}
+		t.names = append(t.names, name)
+		t.hashes = append(t.hashes, sha256.Sum256([]byte(token)))
+	}
+	return t, nil
+}
+
+// Dev — набор с единственным агентом "dev" и токеном DevToken
+func Dev() *Tokens {
+	t, _ := NewTokens(map[string]string{"dev": DevToken})
+	return t
+}
+
+// Identify возвращает имя агента по токену. Сравниваются хеши
+// фиксированной длины за постоянное время.
+func (t *Tokens) Identify(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(token))
+	found := -1
+	for i, h := range t.hashes {
+		if subtle.ConstantTimeCompare(sum[:], h[:]) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return "", false
+	}
+	return t.names[found], true
+}
+
+type identityKey struct{}
+
+// WithIdentity кладет имя агента в контекст
+func WithIdentity(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, identityKey{}, name)
+}
+
+// Identity возвращает имя аутентифицированного агента
+func Identity(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(identityKey{}).(string)
+	return name, ok
+}
+
+// tokenFromMetadata достает токен из "authorization: Bearer ..."
+func tokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+	return ""
+}
+
+// certIdentity возвращает CommonName клиентского сертификата, если
+// TLS его проверил (сервер настроен с CA агентов)
+func certIdentity(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
+
+// UnaryServerInterceptor отклоняет вызовы без действительного токена
+// или проверенного клиентского сертификата
+func (t *Tokens) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		name, ok := t.Identify(tokenFromMetadata(ctx))
+		if !ok {
+			if p, hasPeer := peer.FromContext(ctx); hasPeer {
+				if tlsInfo, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS {
+					name, ok = certIdentity(&tlsInfo.State)
+				}
+			}
+		}
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid agent token")
+		}
+		return handler(WithIdentity(ctx, name), req)
+	}
+}
+
+// Middleware — то же для HTTP API агентов
+func (t *Tokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := t.Identify(r.Header.Get(HeaderName))
+		if !ok {
+			name, ok = certIdentity(r.TLS)
+		}
+		if !ok {
+			apierror.Write(w, r, apierror.InvalidAgentToken, nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), name)))
+	})
+}
+
+// tokenCredentials добавляет токен агента к каждому gRPC вызову
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+// Credentials возвращает учетные данные агента для grpc.WithPerRPCCredentials.
+// secure — требовать ли TLS для передачи токена.
+func Credentials(token string, secure bool) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token, secure: secure}
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool { return c.secure }
+
+// Config описывает, откуда загружать токены агентов
+type Config struct {
+	Production  bool   // APP_ENV=production
+	Tokens      string // AGENT_TOKENS — "имя=токен" через запятую
+	TokensFile  string // AGENT_TOKENS_FILE — файл со строками "имя=токен"
+	ClientCerts bool   // агенты аутентифицируются сертификатами (AGENT_CA_FILE), токены необязательны
+}
+
+// ConfigFromEnv читает настройки токенов агентов из переменных окружения
+func ConfigFromEnv() Config {
+	return Config{
+		Production:  strings.EqualFold(os.Getenv("APP_ENV"), "production"),
+		Tokens:      os.Getenv("AGENT_TOKENS"),
+		TokensFile:  os.Getenv("AGENT_TOKENS_FILE"),
+		ClientCerts: os.Getenv("AGENT_CA_FILE") != "",
+	}
+}
+
+// Load собирает токены агентов по конфигурации. Если ничего не задано,
+// в режиме разработки возвращается Dev(), а в production — ошибка,
+// кроме случая, когда агенты входят только по сертификатам.
+func Load(cfg Config) (*Tokens, error) {
+	lines := strings.Split(cfg.Tokens, ",")
+	if cfg.TokensFile != "" {
+		data, err := os.ReadFile(cfg.TokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("agentauth: read tokens file: %w", err)
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	byName := make(map[string]string)
+	for n, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, "=")
+		if !ok {
+			// Саму строку не выводим: в ней может быть токен
+			return nil, fmt.Errorf("agentauth: entry %d: expected name=token", n+1)
+		}
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if cfg.Production && (len(token) < MinTokenLength || token == DevToken) {
+			return nil, fmt.Errorf("agentauth: token of agent %q is too weak", name)
+		}
+		byName[name] = token
+	}
+
+	if len(byName) == 0 {
+		if cfg.Production && cfg.ClientCerts {
+			return &Tokens{}, nil
+		}
+		if cfg.Production {
+			return nil, errors.New("agentauth: AGENT_TOKENS or AGENT_TOKENS_FILE must be set in production")
+		}
+		log.Printf("ВНИМАНИЕ: токены агентов не заданы, используется токен для разработки")
+		return Dev(), nil
+	}
+	return NewTokens(byName)
+}
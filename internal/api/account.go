@@ -0,0 +1,241 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// loadUser читает профиль пользователя вместе с хешем пароля
+func loadUser(db *sql.DB, userID string) (*models.User, error) {
+	var u models.User
+	err := db.QueryRow("SELECT id, login, password, role, disabled, created_at, updated_at FROM users WHERE id = ?", userID).
+		Scan(&u.ID, &u.Login, &u.Password, &u.Role, &u.Disabled, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// authState — то, что JWTMiddleware проверяет по БД на каждом запросе
+type authState struct {
+	validAfter time.Time // токены с iat раньше недействительны
+	role       string
+	disabled   bool
+}
+
+// loadAuthState читает актуальное состояние пользователя.
+// Ошибка errUserNotFound — аккаунт удален.
+func loadAuthState(db *sql.DB, userID string) (authState, error) {
+	var st authState
+	var after sql.NullTime
+	err := db.QueryRow("SELECT tokens_valid_after, role, disabled FROM users WHERE id = ?", userID).
+		Scan(&after, &st.role, &st.disabled)
+	if err == sql.ErrNoRows {
+		return st, errUserNotFound
+	}
+	st.validAfter = after.Time
+	return st, err
+}
+
+// currentUser достает пользователя из запроса и проверяет его пароль.
+// При ошибке ответ уже записан.
+func currentUser(db *sql.DB, w http.ResponseWriter, r *http.Request, password string) (*models.User, bool) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return nil, false
+	}
+	user, err := loadUser(db, userID)
+	if err == errUserNotFound {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return nil, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return nil, false
+	}
+	if !models.CheckPassword(user.Password, password) {
+		apierror.Write(w, r, apierror.InvalidCredentials, nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// MeHandler — профиль текущего пользователя
+func MeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := loadUser(db, GetUserID(r))
+		if err == errUserNotFound {
+			apierror.Write(w, r, apierror.Unauthorized, nil)
+			return
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// ChangePasswordHandler — смена пароля. Все ранее выданные токены
+// отзываются, в ответе новая пара токенов для текущей сессии.
+func ChangePasswordHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		user, ok := currentUser(db, w, r, req.CurrentPassword)
+		if !ok {
+			return
+		}
+		if err := Policy.Check(user.Login, req.NewPassword); err != nil {
+			writePolicyError(w, r, err)
+			return
+		}
+		hash, err := models.HashPassword(req.NewPassword)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+
+		// iat в JWT хранится с точностью до секунды: граница округляется
+		// вверх, чтобы отозвать и токены, выданные в ту же секунду. Новые
+		// токены получают iat не раньше границы (см. signAccessToken).
+		now := time.Now().UTC()
+		cutoff := now.Truncate(time.Second).Add(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("UPDATE users SET password = ?, updated_at = ?, tokens_valid_after = ? WHERE id = ?",
+			hash, now, cutoff, user.ID); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+			now, user.ID); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+
+		tokens, err := issueTokenPair(db, user.ID, user.Login, user.Role)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// ChangeLoginHandler — смена логина, требует текущий пароль
+func ChangeLoginHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Login           string `json:"login"`
+			CurrentPassword string `json:"current_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		req.Login = strings.TrimSpace(req.Login)
+		if req.Login == "" {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "login"})
+			return
+		}
+		user, ok := currentUser(db, w, r, req.CurrentPassword)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		if _, err := db.Exec("UPDATE users SET login = ?, updated_at = ? WHERE id = ?", req.Login, now, user.ID); err != nil {
+			apierror.Write(w, r, apierror.UserExists, nil)
+			return
+		}
+		user.Login = req.Login
+		user.UpdatedAt = now
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// DeleteAccountHandler — удаление аккаунта со всеми данными пользователя
+func DeleteAccountHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		user, ok := currentUser(db, w, r, req.CurrentPassword)
+		if !ok {
+			return
+		}
+		if err := purgeUser(db, user.ID); err != nil {
+			log.Printf("Ошибка удаления аккаунта %s: %v", user.ID, err)
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		LoginThrottle.reset(db, loginKey(user.Login))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// userDataQueries удаляют все данные пользователя; users удаляется последним
+var userDataQueries = []string{
+	// Права на свои выражения выдает только владелец, поэтому granted_by их покрывает
+	"DELETE FROM expression_grants WHERE user_id = ?1 OR granted_by = ?1",
+	"DELETE FROM share_links WHERE user_id = ?",
+	"DELETE FROM tasks WHERE expression_id IN (SELECT id FROM expressions WHERE user_id = ?)",
+	"DELETE FROM expressions WHERE user_id = ?",
+	"DELETE FROM batches WHERE user_id = ?",
+	"DELETE FROM idempotency_keys WHERE user_id = ?",
+	"DELETE FROM refresh_tokens WHERE user_id = ?",
+	"DELETE FROM api_keys WHERE user_id = ?",
+	"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+	"DELETE FROM webhooks WHERE user_id = ?",
+	"DELETE FROM users WHERE id = ?",
+}
+
+// purgeUser удаляет пользователя и все его данные в одной транзакции
+func purgeUser(db *sql.DB, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range userDataQueries {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
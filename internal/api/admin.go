@@ -0,0 +1,281 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// AgentInfo — что оркестратор знает об агенте, который к нему обращался
+type AgentInfo struct {
+	ID          string    `json:"id"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	TasksLeased int64     `json:"tasks_leased"`
+	Results     int64     `json:"results"`
+}
+
+// agentSeen отмечает обращение агента, leased — агент получил задачу
+func (h *Handler) agentSeen(agentID string, leased bool) {
+	h.agentsMu.Lock()
+	defer h.agentsMu.Unlock()
+	now := time.Now().UTC()
+	a, ok := h.agents[agentID]
+	if !ok {
+		a = &AgentInfo{ID: agentID, FirstSeen: now}
+		h.agents[agentID] = a
+	}
+	a.LastSeen = now
+	if leased {
+		a.TasksLeased++
+	}
+}
+
+// agentResult отмечает результат, присланный агентом
+func (h *Handler) agentResult(agentID string) {
+	h.agentSeen(agentID, false)
+	h.agentsMu.Lock()
+	h.agents[agentID].Results++
+	h.agentsMu.Unlock()
+}
+
+// operationTime возвращает время выполнения операции, мс
+func (h *Handler) operationTime(op string) int64 {
+	h.opMu.RLock()
+	defer h.opMu.RUnlock()
+	return h.opTimes[op]
+}
+
+// loadOperationCosts применяет стоимости, сохраненные через admin API
+func (h *Handler) loadOperationCosts() {
+	if h.db == nil {
+		return
+	}
+	rows, err := h.db.Query("SELECT operation, time_ms FROM operation_costs")
+	if err != nil {
+		log.Printf("Не удалось загрузить стоимость операций: %v", err)
+		return
+	}
+	defer rows.Close()
+	h.opMu.Lock()
+	defer h.opMu.Unlock()
+	for rows.Next() {
+		var op string
+		var ms int64
+		if err := rows.Scan(&op, &ms); err == nil {
+			h.opTimes[op] = ms
+		}
+	}
+}
+
+// EnsureAdmins выдает роль admin пользователям с указанными логинами.
+// Вызывается при старте оркестратора (ADMIN_LOGINS).
+func EnsureAdmins(db *sql.DB, logins []string) error {
+	for _, login := range logins {
+		res, err := db.Exec("UPDATE users SET role = ? WHERE login = ?", models.RoleAdmin, login)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("ADMIN_LOGINS: пользователь %s не найден", login)
+		}
+	}
+	return nil
+}
+
+// AdminListUsersHandler — список всех пользователей
+func (h *Handler) AdminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`SELECT u.id, u.login, u.role, u.disabled, u.created_at, u.updated_at,
+		(SELECT COUNT(*) FROM expressions e WHERE e.user_id = u.id)
+		FROM users u ORDER BY u.created_at`)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	type adminUser struct {
+		models.User
+		Expressions int `json:"expressions"`
+	}
+	users := []adminUser{}
+	for rows.Next() {
+		var u adminUser
+		if err := rows.Scan(&u.ID, &u.Login, &u.Role, &u.Disabled, &u.CreatedAt, &u.UpdatedAt, &u.Expressions); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		users = append(users, u)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": users})
+}
+
+// AdminUpdateUserHandler — смена роли или блокировка пользователя
+func (h *Handler) AdminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	if req.Role != nil && *req.Role != models.RoleUser && *req.Role != models.RoleAdmin {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "role"})
+		return
+	}
+	// Админ не может случайно запереть сам себя
+	if id == GetUserID(r) {
+		apierror.Write(w, r, apierror.CannotChangeOwnAccount, nil)
+		return
+	}
+	user, err := loadUser(h.db, id)
+	if err == errUserNotFound {
+		apierror.Write(w, r, apierror.UserNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
+	if req.Disabled != nil {
+		user.Disabled = *req.Disabled
+	}
+	user.UpdatedAt = time.Now().UTC()
+	_, err = h.db.Exec("UPDATE users SET role = ?, disabled = ?, updated_at = ? WHERE id = ?",
+		user.Role, user.Disabled, user.UpdatedAt, user.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if user.Disabled {
+		// Заблокированный пользователь не должен обновлять токены
+		h.db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", user.UpdatedAt, user.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// AdminListExpressionsHandler — выражения всех пользователей или одного
+// (?user_id=) постранично; остальные параметры — как у GET /api/v1/expressions
+func (h *Handler) AdminListExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"reason": err.Error()})
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	lq.allUsers = userID == ""
+	expressions, nextCursor, total, err := h.listExpressions(userID, lq)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expressions": expressions,
+		"next_cursor": nextCursor,
+		"total":       total,
+	})
+}
+
+// AdminQueueHandler — состояние очереди задач
+func (h *Handler) AdminQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var waiting, done int
+	err := h.db.QueryRow(`SELECT COUNT(*) FILTER (WHERE completed_at IS NULL), COUNT(*) FILTER (WHERE completed_at IS NOT NULL)
+		FROM tasks`).Scan(&waiting, &done)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	var pending int
+	err = h.db.QueryRow("SELECT COUNT(*) FROM expressions WHERE status IN (?, ?)",
+		string(models.StatusPending), string(models.StatusProcessing)).Scan(&pending)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queued":               h.taskQueue.len(),
+		"tasks_without_result": waiting,
+		"tasks_with_result":    done,
+		"pending_expressions":  pending,
+	})
+}
+
+// AdminAgentsHandler — агенты, обращавшиеся к оркестратору
+func (h *Handler) AdminAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	h.agentsMu.Lock()
+	agents := make([]AgentInfo, 0, len(h.agents))
+	for _, a := range h.agents {
+		agents = append(agents, *a)
+	}
+	h.agentsMu.Unlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": agents})
+}
+
+// AdminGetOperationCostsHandler — текущее время выполнения операций, мс
+func (h *Handler) AdminGetOperationCostsHandler(w http.ResponseWriter, r *http.Request) {
+	h.opMu.RLock()
+	costs := make(map[string]int64, len(h.opTimes))
+	for op, ms := range h.opTimes {
+		costs[op] = ms
+	}
+	h.opMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"operation_costs": costs})
+}
+
+// AdminSetOperationCostsHandler — изменение времени выполнения операций.
+// Новые значения применяются к задачам, созданным после изменения.
+func (h *Handler) AdminSetOperationCostsHandler(w http.ResponseWriter, r *http.Request) {
+	var req map[string]int64
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req) == 0 {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	for op, ms := range req {
+		if _, known := DefaultOperationTimes[op]; !known || ms < 0 {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "operation", "value": op})
+			return
+		}
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer tx.Rollback()
+	for op, ms := range req {
+		_, err := tx.Exec(`INSERT INTO operation_costs (operation, time_ms) VALUES (?, ?)
+			ON CONFLICT(operation) DO UPDATE SET time_ms = excluded.time_ms`, op, ms)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	h.opMu.Lock()
+	for op, ms := range req {
+		h.opTimes[op] = ms
+	}
+	h.opMu.Unlock()
+	h.AdminGetOperationCostsHandler(w, r)
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"calculator/internal/apierror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Области доступа API ключей
+const (
+	ScopeCalculate = "calculate" // отправка выражений
+	ScopeRead      = "read"      // чтение выражений и результатов
+)
+
+// apiKeyPrefix отличает API ключи от других секретов (например, в логах)
+const apiKeyPrefix = "ak_"
+
+// MaxAPIKeysPerUser ограничивает число активных ключей одного пользователя
+var MaxAPIKeysPerUser = 20
+
+// authError — ошибка аутентификации с кодом ответа
+type authError struct {
+	code apierror.Code
+}
+
+func (e *authError) Error() string { return string(e.code) }
+
+// APIKey — описание ключа без самого секрета
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func validScope(scope string) bool {
+	return scope == ScopeCalculate || scope == ScopeRead
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIKey генерирует ключ вида ak_<prefix>_<secret>. Prefix хранится
+// открыто и помогает узнать ключ в списке, в БД попадает только хеш ключа.
+func newAPIKey() (key, prefix string, err error) {
+	buf := make([]byte, 36)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	enc := base64.RawURLEncoding.EncodeToString(buf)
+	prefix = strings.NewReplacer("-", "x", "_", "x").Replace(enc[:8])
+	return apiKeyPrefix + prefix + "_" + enc[8:], prefix, nil
+}
+
+// apiKeyFromRequest достает API ключ из "Authorization: ApiKey ..." или X-API-Key
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "ApiKey ") {
+		return strings.TrimPrefix(header, "ApiKey "), true
+	}
+	return "", false
+}
+
+// authenticateAPIKey проверяет ключ и состояние его владельца,
+// отмечает время последнего использования
+func authenticateAPIKey(db *sql.DB, key string) (*UserClaims, []string, error) {
+	var id, userID, scopes string
+	var expiresAt, revokedAt sql.NullTime
+	err := db.QueryRow("SELECT id, user_id, scopes, expires_at, revoked_at FROM api_keys WHERE key_hash = ?",
+		hashToken(key)).Scan(&id, &userID, &scopes, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, &authError{apierror.InvalidAPIKey}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	if revokedAt.Valid || (expiresAt.Valid && now.After(expiresAt.Time)) {
+		return nil, nil, &authError{apierror.InvalidAPIKey}
+	}
+
+	var login, role string
+	var disabled bool
+	err = db.QueryRow("SELECT login, role, disabled FROM users WHERE id = ?", userID).Scan(&login, &role, &disabled)
+	if err == sql.ErrNoRows {
+		return nil, nil, &authError{apierror.InvalidAPIKey}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if disabled {
+		return nil, nil, &authError{apierror.AccountDisabled}
+	}
+	if _, err := db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, id); err != nil {
+		return nil, nil, err
+	}
+	claims := &UserClaims{UserID: userID, Login: login, Role: role}
+	return claims, strings.Split(scopes, ","), nil
+}
+
+// CreateAPIKeyHandler — выпуск API ключа. Ключ возвращается только в этом ответе.
+func CreateAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ExpiresIn string   `json:"expires_in"` // например "720h"; пусто — бессрочный
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 100 {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "name"})
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{ScopeCalculate, ScopeRead}
+		}
+		for _, s := range req.Scopes {
+			if !validScope(s) {
+				apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "scopes", "value": s})
+				return
+			}
+		}
+		now := time.Now().UTC()
+		var expiresAt *time.Time
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "expires_in"})
+				return
+			}
+			t := now.Add(d)
+			expiresAt = &t
+		}
+
+		userID := GetUserID(r)
+		var active int
+		err := db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > ?)`, userID, now).Scan(&active)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if active >= MaxAPIKeysPerUser {
+			apierror.Write(w, r, apierror.TooManyAPIKeys, nil)
+			return
+		}
+
+		key, prefix, err := newAPIKey()
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		info := APIKey{
+			ID:        uuid.New().String(),
+			Name:      req.Name,
+			Prefix:    prefix,
+			Scopes:    req.Scopes,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		}
+		_, err = db.Exec(`INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			info.ID, userID, info.Name, info.Prefix, hashToken(key), strings.Join(info.Scopes, ","), now, expiresAt)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			APIKey
+			Key string `json:"key"`
+		}{info, key})
+	}
+}
+
+// ListAPIKeysHandler — ключи текущего пользователя, включая отозванные
+func ListAPIKeysHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at
+			FROM api_keys WHERE user_id = ? ORDER BY created_at`, GetUserID(r))
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		defer rows.Close()
+		keys := []APIKey{}
+		for rows.Next() {
+			var k APIKey
+			var scopes string
+			var expiresAt, lastUsedAt, revokedAt sql.NullTime
+			if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+				apierror.Write(w, r, apierror.InternalError, nil)
+				return
+			}
+			k.Scopes = strings.Split(scopes, ",")
+			k.ExpiresAt = nullTimePtr(expiresAt)
+			k.LastUsedAt = nullTimePtr(lastUsedAt)
+			k.RevokedAt = nullTimePtr(revokedAt)
+			keys = append(keys, k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": keys})
+	}
+}
+
+// RevokeAPIKeyHandler — отзыв ключа текущего пользователя
+func RevokeAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+			time.Now().UTC(), mux.Vars(r)["id"], GetUserID(r))
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			apierror.Write(w, r, apierror.APIKeyNotFound, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
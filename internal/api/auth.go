@@ -3,24 +3,54 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
+	"calculator/internal/apierror"
+	"calculator/internal/jwtkeys"
 	"calculator/internal/models"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 )
 
-var jwtKey = []byte("super_secret_key")
+// jwtKeys — ключи подписи JWT. По умолчанию ключ для разработки,
+// оркестратор заменяет его через SetKeys при старте.
+var jwtKeys = jwtkeys.Dev()
+
+// SetKeys задает набор ключей для подписи и проверки JWT
+func SetKeys(ks *jwtkeys.KeySet) {
+	jwtKeys = ks
+}
 
 // UserClaims описывает содержимое JWT
 // (можно расширить при необходимости)
 type UserClaims struct {
 	UserID string `json:"user_id"`
 	Login  string `json:"login"`
+	Role   string `json:"role"`
 	jwt.RegisteredClaims
 }
 
+// Valid проверяет стандартные поля, но допускает iat на секунду впереди
+// часов: токены после смены пароля датируются следующей секундой
+func (c UserClaims) Valid() error {
+	claims := c.RegisteredClaims
+	if claims.IssuedAt != nil {
+		claims.IssuedAt = jwt.NewNumericDate(claims.IssuedAt.Add(-time.Second))
+	}
+	return claims.Valid()
+}
+
+// JWKSHandler отдает публичные ключи проверки JWT для других сервисов
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jwtKeys.JWKS())
+}
+
 // RegisterHandler — регистрация пользователя
 func RegisterHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -29,60 +59,117 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			Password string `json:"password"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest, nil)
 			return
 		}
 		if req.Login == "" || req.Password == "" {
-			http.Error(w, "login and password required", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.CredentialsRequired, nil)
+			return
+		}
+		if err := Policy.Check(req.Login, req.Password); err != nil {
+			writePolicyError(w, r, err)
 			return
 		}
 		id := uuid.New().String()
-		hash := models.HashPassword(req.Password)
-		_, err := db.Exec("INSERT INTO users (id, login, password) VALUES (?, ?, ?)", id, req.Login, hash)
+		hash, err := models.HashPassword(req.Password)
 		if err != nil {
-			http.Error(w, "user already exists", http.StatusConflict)
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		now := time.Now().UTC()
+		_, err = db.Exec("INSERT INTO users (id, login, password, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			id, req.Login, hash, now, now)
+		if err != nil {
+			apierror.Write(w, r, apierror.UserExists, nil)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-// LoginHandler — вход пользователя и выдача JWT
+// LoginHandler — вход пользователя и выдача пары access/refresh токенов.
+// Неизвестный логин и неверный пароль дают одинаковый ответ за одинаковое
+// время, а после серии неудач логин и IP временно блокируются.
 func LoginHandler(db *sql.DB) http.HandlerFunc {
-	defaultTokenTTL := time.Hour * 24
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Login    string `json:"login"`
 			Password string `json:"password"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		// Слишком длинный пароль не хешируем: это дорогая операция
+		if Policy.MaxLength > 0 && utf8.RuneCountInString(req.Password) > Policy.MaxLength {
+			apierror.Write(w, r, apierror.InvalidCredentials, nil)
 			return
 		}
-		var id, hash string
-		err := db.QueryRow("SELECT id, password FROM users WHERE login = ?", req.Login).Scan(&id, &hash)
+
+		userKey, addrKey := loginKey(req.Login), ipKey(r)
+		wait, err := LoginThrottle.retryAfter(db, userKey, addrKey)
 		if err != nil {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if wait > 0 {
+			retryAfter := int(wait.Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Write(w, r, apierror.TooManyLoginAttempts, apierror.Details{"retry_after": retryAfter})
 			return
 		}
-		if !models.CheckPassword(hash, req.Password) {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+
+		var id, hash, role string
+		var disabled bool
+		err = db.QueryRow("SELECT id, password, role, disabled FROM users WHERE login = ?", req.Login).
+			Scan(&id, &hash, &role, &disabled)
+		if err != nil && err != sql.ErrNoRows {
+			apierror.Write(w, r, apierror.InternalError, nil)
 			return
 		}
-		claims := UserClaims{
-			UserID: id,
-			Login:  req.Login,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(defaultTokenTTL)),
-			},
+		known := err == nil
+		if !known {
+			hash = getDummyHash()
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString(jwtKey)
+		if !models.CheckPassword(hash, req.Password) || !known {
+			if err := LoginThrottle.fail(db, userKey, LoginThrottle.MaxFailures); err != nil {
+				log.Printf("Ошибка учета неудачного входа: %v", err)
+			}
+			if err := LoginThrottle.fail(db, addrKey, LoginThrottle.MaxIPFailures); err != nil {
+				log.Printf("Ошибка учета неудачного входа: %v", err)
+			}
+			apierror.Write(w, r, apierror.InvalidCredentials, nil)
+			return
+		}
+		LoginThrottle.reset(db, userKey)
+		if disabled {
+			apierror.Write(w, r, apierror.AccountDisabled, nil)
+			return
+		}
+		rehashPassword(db, id, hash, req.Password)
+		tokens, err := issueTokenPair(db, id, req.Login, role)
 		if err != nil {
-			http.Error(w, "could not sign token", http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.InternalError, nil)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"token": signed})
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// rehashPassword пересчитывает хеш устаревшего формата после успешного входа.
+// Ошибка не мешает входу: хеш обновится в следующий раз.
+func rehashPassword(db *sql.DB, userID, oldHash, password string) {
+	if !models.NeedsRehash(oldHash) {
+		return
+	}
+	newHash, err := models.HashPassword(password)
+	if err != nil {
+		log.Printf("Ошибка пересчета хеша пароля: %v", err)
+		return
+	}
+	// Условие на старый хеш защищает от гонки с параллельной сменой пароля
+	if _, err := db.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", newHash, userID, oldHash); err != nil {
+		log.Printf("Ошибка сохранения нового хеша пароля: %v", err)
 	}
 }
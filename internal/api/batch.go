@@ -0,0 +1,144 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// BatchMaxItems — наибольшее число выражений в одном пакете
+var BatchMaxItems = 1000
+
+// batchRequest — тело POST /api/v1/calculate/batch
+type batchRequest struct {
+	Items []models.CalculationRequest `json:"items"`
+}
+
+// BatchItem — результат приема одного элемента пакета: id выражения или ошибка
+type BatchItem struct {
+	Index int             `json:"index"`
+	Ref   string          `json:"ref,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Error *apierror.Error `json:"error,omitempty"`
+}
+
+// BatchProgress — сводный ход вычисления пакета
+type BatchProgress struct {
+	ID        string                           `json:"id"`
+	CreatedAt time.Time                        `json:"created_at"`
+	ItemCount int                              `json:"item_count"`
+	Statuses  map[models.CalculationStatus]int `json:"statuses"`
+	TaskCount int                              `json:"task_count"`
+	TasksDone int                              `json:"tasks_done"`
+	Finished  bool                             `json:"finished"` // все выражения completed или failed
+}
+
+// CalculateBatchHandler — POST /api/v1/calculate/batch. Каждое выражение
+// проверяется отдельно; корректные сохраняются в одной транзакции,
+// для остальных в ответе ошибка разбора. Если корректных нет — 422.
+func (h *Handler) CalculateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > BatchMaxItems {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "items", "min": 1, "max": BatchMaxItems})
+		return
+	}
+
+	items := make([]BatchItem, len(req.Items))
+	var valid []compiledExpression
+	var validIdx []int
+	for i, item := range req.Items {
+		items[i] = BatchItem{Index: i, Ref: item.Ref}
+		compiled, err := compileExpression(item)
+		if err != nil {
+			// request_id один на весь пакет, в элементах он не нужен
+			e := syntaxError(r, err)
+			e.RequestID = ""
+			items[i].Error = &e
+			continue
+		}
+		valid = append(valid, compiled)
+		validIdx = append(validIdx, i)
+	}
+	if len(valid) == 0 {
+		apierror.Write(w, r, apierror.InvalidExpressions, apierror.Details{"items": items})
+		return
+	}
+
+	batchID := uuid.New().String()
+	ids, err := h.createExpressions(userID, batchID, valid)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	for i, id := range ids {
+		items[validIdx[i]].ID = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id": batchID,
+		"items":    items,
+	})
+}
+
+// GetBatchHandler — GET /api/v1/batches/{id}: число выражений пакета
+// по статусам и общий счет задач. Пакет виден только владельцу.
+func (h *Handler) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	var batch BatchProgress
+	err := h.db.QueryRow("SELECT id, created_at, item_count FROM batches WHERE id = ? AND user_id = ?",
+		mux.Vars(r)["id"], userID).Scan(&batch.ID, &batch.CreatedAt, &batch.ItemCount)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.BatchNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	rows, err := h.db.Query(`SELECT status, COUNT(*), SUM(task_count), SUM(tasks_done) FROM expressions
+		WHERE batch_id = ? GROUP BY status`, batch.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	batch.Statuses = map[models.CalculationStatus]int{}
+	finished := 0
+	for rows.Next() {
+		var status models.CalculationStatus
+		var n, tasks, done int
+		if err := rows.Scan(&status, &n, &tasks, &done); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		batch.Statuses[status] = n
+		batch.TaskCount += tasks
+		batch.TasksDone += done
+		if terminal(status) {
+			finished += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	batch.Finished = finished == batch.ItemCount
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch": batch})
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/calculator"
+	"calculator/internal/models"
+)
+
+// Режимы POST /api/v1/evaluate
+const (
+	EvaluateLocal       = "local"       // вычисление в оркестраторе, без агентов
+	EvaluateDistributed = "distributed" // обычный путь через агентов с ожиданием результата
+)
+
+// EvaluateTimeout — ожидание результата в режиме distributed по умолчанию;
+// предел — MaxWait
+var EvaluateTimeout = 10 * time.Second
+
+// evaluateRequest — тело POST /api/v1/evaluate
+type evaluateRequest struct {
+	models.CalculationRequest
+	Mode    string `json:"mode"`
+	Persist bool   `json:"persist"` // сохранить выражение в истории (local)
+	Timeout string `json:"timeout"` // ожидание результата (distributed)
+}
+
+// EvaluateHandler — POST /api/v1/evaluate: вычисляет выражение и сразу
+// возвращает результат. В режиме local выражение считается в оркестраторе
+// и по умолчанию не сохраняется; в режиме distributed оно проходит
+// обычный путь через агентов, а запрос ждет результат до timeout.
+func (h *Handler) EvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	var req evaluateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	switch req.Mode {
+	case "", EvaluateLocal:
+		h.evaluateLocal(w, r, userID, req)
+	case EvaluateDistributed:
+		timeout := EvaluateTimeout
+		if req.Timeout != "" {
+			d, err := time.ParseDuration(req.Timeout)
+			if err != nil || d <= 0 || d > MaxWait {
+				apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "timeout", "max": MaxWait.String()})
+				return
+			}
+			timeout = d
+		}
+		h.evaluateDistributed(w, r, userID, req.CalculationRequest, timeout)
+	default:
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "mode"})
+	}
+}
+
+func (h *Handler) evaluateLocal(w http.ResponseWriter, r *http.Request, userID string, req evaluateRequest) {
+	item, err := compileExpression(req.CalculationRequest)
+	if err != nil {
+		writeSyntaxError(w, r, err)
+		return
+	}
+	result, err := calculator.NewCalculator().Run(item.steps, item.value)
+	switch {
+	case errors.Is(err, calculator.ErrDivisionByZero):
+		item.failure = failureDivisionByZero
+	case err != nil:
+		item.failure = err.Error()
+	case math.IsInf(result, 0) || math.IsNaN(result):
+		item.failure = failureNotFinite
+	}
+	id := ""
+	if req.Persist {
+		// Сохраняется уже вычисленным, без задач
+		item.steps, item.value = nil, result
+		ids, err := h.createExpressions(userID, "", []compiledExpression{item})
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		id = ids[0]
+	}
+	if item.failure != "" {
+		writeEvaluationError(w, r, id, item.failure)
+		return
+	}
+	writeEvaluation(w, id, result)
+}
+
+func (h *Handler) evaluateDistributed(w http.ResponseWriter, r *http.Request, userID string, req models.CalculationRequest, timeout time.Duration) {
+	id, err := h.submitExpression(userID, req)
+	if errors.Is(err, errInvalidExpression) {
+		writeSyntaxError(w, r, err)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	// Выражение могло завершиться до подписки, поэтому после нее читаем из БД
+	done, cancel := h.waiters.subscribe(id)
+	defer cancel()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		expr, err := scanExpression(h.db.QueryRow("SELECT "+expressionColumns+" FROM expressions e WHERE e.id = ?", id))
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		switch {
+		case expr.Status == models.StatusFailed:
+			writeEvaluationError(w, r, id, expr.Error)
+			return
+		case expr.Status == models.StatusCompleted && expr.Result != nil:
+			writeEvaluation(w, id, *expr.Result)
+			return
+		}
+		if done == nil {
+			// Не успели: выражение считается дальше, результат — по id
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": expr.Status})
+			return
+		}
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+		done = nil
+	}
+}
+
+func writeEvaluation(w http.ResponseWriter, id string, result float64) {
+	resp := map[string]interface{}{"result": result}
+	if id != "" {
+		resp["id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeEvaluationError отвечает 422, когда выражение разобрано, но операция
+// дала недопустимый результат; reason совпадает с полем error выражения
+func writeEvaluationError(w http.ResponseWriter, r *http.Request, id, failure string) {
+	details := apierror.Details{"reason": failure}
+	if id != "" {
+		details["id"] = id
+	}
+	apierror.Write(w, r, apierror.EvaluationFailed, details)
+}
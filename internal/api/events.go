@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+)
+
+// Типы событий потока GET /api/v1/events
+const (
+	EventExpressionCreated   = "expression.created"
+	EventExpressionProgress  = "expression.progress"
+	EventExpressionCompleted = "expression.completed"
+	EventExpressionFailed    = "expression.failed"
+)
+
+// Параметры потока событий
+var (
+	EventReplaySize       = 1000             // сколько последних событий хранится для Last-Event-ID
+	HeartbeatInterval     = 15 * time.Second // комментарий-пульс, чтобы прокси не закрывали соединение
+	eventSubscriberBuffer = 64
+)
+
+// Event — изменение выражения пользователя. Data — выражение целиком,
+// для progress в нем важны tasks_done и task_count.
+type Event struct {
+	ID     uint64            `json:"id"`
+	Type   string            `json:"type"`
+	UserID string            `json:"-"`
+	Data   models.Expression `json:"data"`
+}
+
+// eventBroker раздает события подписчикам и хранит кольцевой буфер
+// последних событий для возобновления потока
+type eventBroker struct {
+	mu     sync.Mutex
+	nextID uint64
+	replay []Event // по возрастанию ID, не длиннее EventReplaySize
+	subs   map[chan Event]string
+}
+
+// publish рассылает событие подписчикам пользователя. Подписчик, который
+// не успевает читать, отключается: клиент переподключится с Last-Event-ID.
+func (b *eventBroker) publish(typ string, expr models.Expression) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e := Event{ID: b.nextID, Type: typ, UserID: expr.UserID, Data: expr}
+	b.replay = append(b.replay, e)
+	if n := len(b.replay) - EventReplaySize; n > 0 {
+		b.replay = append(b.replay[:0:0], b.replay[n:]...)
+	}
+	for ch, userID := range b.subs {
+		if userID != e.UserID {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe возвращает события пользователя после lastID из буфера
+// и канал новых событий. Канал закрывается при отставании подписчика.
+func (b *eventBroker) subscribe(userID string, lastID uint64) ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, eventSubscriberBuffer)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan Event]string)
+	}
+	b.subs[ch] = userID
+	// ID больше выданных — поток от прошлого запуска сервера: его события
+	// не восстановить, а события этого запуска клиент еще не видел
+	if lastID > b.nextID {
+		lastID = 0
+	}
+	var missed []Event
+	for _, e := range b.replay {
+		if e.ID > lastID && e.UserID == userID {
+			missed = append(missed, e)
+		}
+	}
+	b.mu.Unlock()
+	return missed, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// lastID — ID последнего выданного события
+func (b *eventBroker) lastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID
+}
+
+// lastEventID — заголовок Last-Event-ID, который браузер шлет при
+// переподключении, или параметр ?last_event_id=
+func lastEventID(r *http.Request) uint64 {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return id
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// EventsHandler — поток Server-Sent Events об изменениях выражений
+// пользователя. Поддерживает возобновление по Last-Event-ID.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	missed, events, cancel := h.events.subscribe(userID, lastEventID(r))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	for _, e := range missed {
+		if writeEvent(w, e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// Отстали от потока — закрываем, клиент продолжит с Last-Event-ID
+				return
+			}
+			if writeEvent(w, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+)
+
+// Размер страницы списка выражений
+var (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// listQuery — разобранные параметры GET /api/v1/expressions
+type listQuery struct {
+	limit         int
+	sort          string // created_at или result
+	desc          bool
+	status        string
+	createdAfter  *time.Time
+	createdBefore *time.Time
+	q             string
+	cursor        *listCursor
+	allUsers      bool // без условия на владельца, только для администратора
+}
+
+// listCursor — позиция последнего отданного элемента. Курсор привязан
+// к сортировке: с другим sort/order он недействителен.
+type listCursor struct {
+	Sort      string     `json:"s"`
+	Desc      bool       `json:"d"`
+	ID        string     `json:"id"`
+	CreatedAt *time.Time `json:"c,omitempty"`
+	Result    *float64   `json:"r,omitempty"`
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+func validStatus(s string) bool {
+	switch models.CalculationStatus(s) {
+	case models.StatusPending, models.StatusProcessing, models.StatusCompleted, models.StatusFailed:
+		return true
+	}
+	return false
+}
+
+// parseListQuery проверяет параметры запроса списка
+func parseListQuery(r *http.Request) (*listQuery, error) {
+	v := r.URL.Query()
+	lq := &listQuery{limit: DefaultPageSize, sort: "created_at", desc: true}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return nil, errors.New("limit must be between 1 and " + strconv.Itoa(MaxPageSize))
+		}
+		lq.limit = n
+	}
+	switch s := v.Get("sort"); s {
+	case "", "created_at":
+	case "result":
+		lq.sort = "result"
+	default:
+		return nil, errors.New("sort must be created_at or result")
+	}
+	switch s := v.Get("order"); s {
+	case "", "desc":
+	case "asc":
+		lq.desc = false
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	if s := v.Get("status"); s != "" {
+		if !validStatus(s) {
+			return nil, errors.New("unknown status: " + s)
+		}
+		lq.status = s
+	}
+	for param, dst := range map[string]**time.Time{"created_after": &lq.createdAfter, "created_before": &lq.createdBefore} {
+		if s := v.Get(param); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, errors.New(param + " must be RFC 3339 time")
+			}
+			t = t.UTC()
+			*dst = &t
+		}
+	}
+	lq.q = v.Get("q")
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort == "created_at" && c.CreatedAt == nil {
+			return nil, errors.New("invalid cursor")
+		}
+		if c.Sort != lq.sort || c.Desc != lq.desc {
+			return nil, errors.New("cursor does not match sort and order")
+		}
+		lq.cursor = c
+	}
+	return lq, nil
+}
+
+// escapeLike экранирует спецсимволы LIKE, чтобы q искался как подстрока
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// where собирает условия фильтров; курсор в них не входит,
+// чтобы тот же запрос подходил для подсчета total
+func (lq *listQuery) where(userID string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if !lq.allUsers {
+		conds = append(conds, "user_id = ?")
+		args = append(args, userID)
+	}
+	if lq.status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, lq.status)
+	}
+	if lq.createdAfter != nil {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, *lq.createdAfter)
+	}
+	if lq.createdBefore != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, *lq.createdBefore)
+	}
+	if lq.q != "" {
+		conds = append(conds, `expression LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(lq.q)+"%")
+	}
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// after — условие "строго после курсора" для keyset пагинации.
+// Выражения без результата при сортировке по result идут в конце.
+func (lq *listQuery) after() (string, []interface{}) {
+	c := lq.cursor
+	cmp := ">"
+	if lq.desc {
+		cmp = "<"
+	}
+	if lq.sort == "created_at" {
+		return "(created_at " + cmp + " ? OR (created_at = ? AND id " + cmp + " ?))",
+			[]interface{}{*c.CreatedAt, *c.CreatedAt, c.ID}
+	}
+	if c.Result == nil {
+		return "(result IS NULL AND id " + cmp + " ?)", []interface{}{c.ID}
+	}
+	return "(result " + cmp + " ? OR (result = ? AND id " + cmp + " ?) OR result IS NULL)",
+		[]interface{}{*c.Result, *c.Result, c.ID}
+}
+
+func (lq *listQuery) orderBy() string {
+	dir := "ASC"
+	if lq.desc {
+		dir = "DESC"
+	}
+	if lq.sort == "result" {
+		return "(result IS NULL), result " + dir + ", id " + dir
+	}
+	return "created_at " + dir + ", id " + dir
+}
+
+// listExpressions — страница выражений пользователя, курсор следующей
+// страницы (пусто, если ее нет) и число выражений под фильтрами
+func (h *Handler) listExpressions(userID string, lq *listQuery) ([]models.Expression, string, int, error) {
+	where, args := lq.where(userID)
+	var total int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM expressions e WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, "", 0, err
+	}
+	if lq.cursor != nil {
+		cond, cargs := lq.after()
+		where += " AND " + cond
+		args = append(args, cargs...)
+	}
+	// Берем на одну запись больше, чтобы узнать, есть ли следующая страница
+	args = append(args, lq.limit+1)
+	rows, err := h.db.Query("SELECT "+expressionColumns+" FROM expressions e WHERE "+
+		where+" ORDER BY "+lq.orderBy()+" LIMIT ?", args...)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer rows.Close()
+	expressions := []models.Expression{}
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		expressions = append(expressions, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", 0, err
+	}
+
+	var nextCursor string
+	if len(expressions) > lq.limit {
+		expressions = expressions[:lq.limit]
+		last := expressions[lq.limit-1]
+		nextCursor = listCursor{Sort: lq.sort, Desc: lq.desc, ID: last.ID, CreatedAt: last.CreatedAt, Result: last.Result}.encode()
+	}
+	return expressions, nextCursor, total, nil
+}
+
+// GetExpressionsHandler — выражения пользователя постранично.
+// Параметры: limit, cursor, status, created_after, created_before, q,
+// sort (created_at|result), order (asc|desc).
+func (h *Handler) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	lq, err := parseListQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"reason": err.Error()})
+		return
+	}
+
+	expressions, nextCursor, total, err := h.listExpressions(userID, lq)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expressions": expressions,
+		"next_cursor": nextCursor,
+		"total":       total,
+	})
+}
@@ -3,296 +3,305 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"calculator/internal/agentauth"
+	"calculator/internal/apierror"
 	"calculator/internal/calculator"
 	"calculator/internal/models"
 	"calculator/calculatorpb"
-	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 // Handler теперь содержит ссылку на БД
-// sync.Map будут удалены после полной миграции на БД
+// Выражения и задачи хранятся в БД, в памяти только очередь на выдачу
 
 // --- gRPC integration methods ---
 // Вернуть задачу для gRPC агента
-func (h *Handler) GetTaskForAgent() (*calculatorpb.Task, bool) {
-	select {
-	case task := <-h.taskQueue:
-		// Используем простой ID для задачи - 1, 2, 3, ...
-		// Это не важно, так как реальный ID хранится в поле Operation
-		// Мы вставим реальный ID задачи (строку) в поле Operation, а затем восстановим его в SubmitAgentResult
-		taskOperation := task.Operation // Сохраняем реальную операцию
-		taskID := task.ID           // Сохраняем реальный ID
-		
+func (h *Handler) GetTaskForAgent(agentID string) (*calculatorpb.Task, bool) {
+	h.agentSeen(agentID, false)
+	if task, ok := h.taskQueue.pop(); ok {
+		h.leaseTask(&task, agentID)
+		id, _ := strconv.ParseInt(task.ID, 10, 64)
+
 		// Формируем специальную строку с данными для агента
-		// Формат: "REAL_ID||OPERATION"
-		specialOperation := taskID + "||" + taskOperation
-		
-		log.Printf("Отправляем задачу агенту: ID=%s, операция=%s, аргументы: %f %f", 
-			taskID, taskOperation, task.Arg1, task.Arg2)
-		
+		// Формат: "ID||OPERATION", агент берет операцию из второй части
+		specialOperation := task.ID + "||" + task.Operation
+
+		// 'g' с точностью -1 — кратчайшая запись, которая читается обратно
+		// в то же число; %f обрезал бы промежуточные результаты до 6 знаков
+		arg1 := strconv.FormatFloat(task.Arg1, 'g', -1, 64)
+		arg2 := strconv.FormatFloat(task.Arg2, 'g', -1, 64)
+		log.Printf("Отправляем задачу агенту: ID=%s, операция=%s, аргументы: %s %s",
+			task.ID, task.Operation, arg1, arg2)
+
 		return &calculatorpb.Task{
-			Id:        1, // Упрощенный ID для gRPC
-			Arg1:      fmt.Sprintf("%f", task.Arg1),
-			Arg2:      fmt.Sprintf("%f", task.Arg2),
-			Operation: specialOperation, // Здесь передаем и ID, и операцию
+			Id:            id,
+			Arg1:          arg1,
+			Arg2:          arg2,
+			Operation:     specialOperation,
 			OperationTime: task.OperationTime,
 		}, true
-	default:
-		return nil, false
 	}
+	return nil, false
 }
 
 // Принять результат от gRPC агента
-func (h *Handler) SubmitAgentResult(taskID int64, result float64) error {
-	// taskID теперь не используется, так как мы передаем ID задачи в Operation
-	// Восстанавливаем реальный ID из поля Operation в последнем запросе GetTask
-	
-	// Находим реальный ID задачи, сохраненный в последнем GetTask
-	// Циклически просматриваем все задачи и ищем ту, которая еще не имеет результата (TaskResult)
-	
-	var foundTaskID string
-	h.tasks.Range(func(key, value interface{}) bool {
-		id, ok1 := key.(string)
-		_, ok2 := value.(models.Task)
-		if ok1 && ok2 {
-			// Проверяем, что это Task, а не TaskResult
-			// Для этой задачи еще нет результата, поэтому ее можно использовать
-			foundTaskID = id
-			return false // Прерываем поиск после нахождения первой подходящей задачи
-		}
-		return true // Продолжаем поиск
-	})
-	
-	if foundTaskID == "" {
-		return fmt.Errorf("задача не найдена")
-	}
-	
-	// Сохраняем результат с реальным ID задачи
-	h.tasks.Store(foundTaskID, models.TaskResult{ID: foundTaskID, Result: result})
-	log.Printf("Получен результат для задачи %s: %f", foundTaskID, result)
-	
-	// Находим ОДНО выражение со статусом "pending" и обновляем его
-	var exprID string
-	err := h.db.QueryRow("SELECT id FROM expressions WHERE status = ? LIMIT 1", string(models.StatusPending)).Scan(&exprID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Не найдено выражений со статусом pending")
-			return nil // Выражений для обновления нет, это не ошибка
-		}
-		log.Printf("Ошибка при запросе к БД: %v", err)
-		return err
-	}
-	
-	// Функция для обновления с повторными попытками
-	updateWithRetry := func() error {
-		for i := 0; i < 3; i++ { // Пробуем 3 раза
-			_, err := h.db.Exec("UPDATE expressions SET status = ?, result = ? WHERE id = ?", 
-				string(models.StatusCompleted), result, exprID)
-			if err == nil {
-				log.Printf("Обновлено выражение %s: статус=completed, результат=%f", exprID, result)
-				return nil // Успешно
-			}
-			log.Printf("Попытка %d: Ошибка при обновлении выражения: %v", i+1, err)
-			time.Sleep(time.Millisecond * 200 * time.Duration(i+1)) // Увеличиваем задержку между попытками
-		}
-		return fmt.Errorf("не удалось обновить выражение после 3 попыток")
-	}
-	
-	// Обновляем запись с повторными попытками
-	err = updateWithRetry()
-	if err != nil {
-		log.Printf("Критическая ошибка при обновлении выражения: %v", err)
-		return err
-	}
-	
-	return nil
+func (h *Handler) SubmitAgentResult(agentID string, taskID int64, result float64) error {
+	h.agentSeen(agentID, false)
+	return h.completeTask(agentID, taskID, result)
 }
 // --- END gRPC integration methods ---
 
 type Handler struct {
 	db *sql.DB
-	expressions sync.Map
-	tasks       sync.Map
-	taskQueue   chan models.Task
+	taskQueue   taskQueue
+
+	opMu    sync.RWMutex
+	opTimes map[string]int64 // время выполнения операций, мс
+
+	agentsMu sync.Mutex
+	agents   map[string]*AgentInfo
+
+	waiters waiters     // long-poll ожидания результатов выражений
+	events  eventBroker // поток событий GET /api/v1/events
+
+	webhookWake chan struct{} // будит RunWebhookDispatcher после новых событий
+
+	fullText bool // поиск через индекс expressions_fts, иначе через LIKE
+}
+
+// DefaultOperationTimes — время выполнения операций по умолчанию, мс.
+// Оркестратор заполняет его из TIME_*_MS до создания Handler.
+var DefaultOperationTimes = map[string]int64{
+	"+": 1000,
+	"-": 1000,
+	"*": 2000,
+	"/": 2000,
 }
 
 func NewHandler(db *sql.DB) *Handler {
-	return &Handler{
+	h := &Handler{
 		db:       db,
-		taskQueue: make(chan models.Task, 100),
+		opTimes:   make(map[string]int64),
+		agents:    make(map[string]*AgentInfo),
+
+		webhookWake: make(chan struct{}, 1),
+	}
+	for op, ms := range DefaultOperationTimes {
+		h.opTimes[op] = ms
+	}
+	h.loadOperationCosts()
+	h.restoreQueue()
+	h.fullText = models.FullTextSearch(db)
+	return h
+}
+
+// errInvalidExpression — выражение не удалось разобрать
+var errInvalidExpression = errors.New("invalid expression")
+
+// submitExpression проверяет выражение и ставит его задачи в очередь.
+// Общий путь для POST /api/v1/calculate и WebSocket API.
+func (h *Handler) submitExpression(userID string, req models.CalculationRequest) (string, error) {
+	item, err := compileExpression(req)
+	if err != nil {
+		return "", err
+	}
+	ids, err := h.createExpressions(userID, "", []compiledExpression{item})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// syntaxError — ошибка разбора в едином формате; код совпадает с кодом
+// calculator.SyntaxError, позиция — в details. Ошибка метаданных
+// выражения — invalid_parameter с полем в details.
+func syntaxError(r *http.Request, err error) apierror.Error {
+	var metaErr *metadataError
+	if errors.As(err, &metaErr) {
+		return apierror.New(r, apierror.InvalidParameter, metaErr.details())
+	}
+	var syntaxErr *calculator.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return apierror.New(r, apierror.UnexpectedToken, nil)
 	}
+	return apierror.New(r, apierror.Code(syntaxErr.Code), apierror.Details{"position": syntaxErr.Pos})
+}
+
+// writeSyntaxError отвечает 422 с кодом и позицией ошибки разбора,
+// на ошибку метаданных — 400
+func writeSyntaxError(w http.ResponseWriter, r *http.Request, err error) {
+	e := syntaxError(r, err)
+	apierror.Write(w, r, e.Code, e.Details)
 }
 
 func (h *Handler) CalculateHandler(w http.ResponseWriter, r *http.Request) {
 	userID := GetUserID(r)
 	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, apierror.Unauthorized, nil)
 		return
 	}
 
 	var req models.CalculationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Плохой запрос", http.StatusBadRequest)
-		return
-	}
-
-	id := uuid.New().String()
-	_, err := h.db.Exec("INSERT INTO expressions (id, expression, status, user_id) VALUES (?, ?, ?, ?)", id, req.Expression, string(models.StatusPending), userID)
-	if err != nil {
-		http.Error(w, "DB error", http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.BadRequest, nil)
 		return
 	}
 
-	parser := calculator.NewParser(req.Expression)
-	operations, err := parser.Parse()
-	if err != nil {
-		http.Error(w, "Ошибка разбора выражения", http.StatusUnprocessableEntity)
+	// Повтор с тем же Idempotency-Key получает исходный ответ
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > MaxIdempotencyKeyLength {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": IdempotencyKeyHeader, "max_length": MaxIdempotencyKeyLength})
 		return
 	}
-
-	for _, op := range operations {
-		taskID := uuid.New().String()
-		task := models.Task{
-			ID:        taskID,
-			Arg1:      op.Operand1,
-			Arg2:      op.Operand2,
-			Operation: op.Operator,
+	if key != "" {
+		hash, err := requestHash(req)
+		if err != nil {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		stored, err := h.reserveIdempotencyKey(userID, key, hash)
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			apierror.Write(w, r, apierror.IdempotencyKeyMismatch, nil)
+			return
+		case errors.Is(err, errIdempotencyInProgress):
+			apierror.Write(w, r, apierror.IdempotencyKeyInProgress, nil)
+			return
+		case err != nil:
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		case stored != nil:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
 		}
-		h.tasks.Store(taskID, task)
-		h.taskQueue <- task
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
-}
-
-func (h *Handler) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	userID := GetUserID(r)
-	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	id, err := h.submitExpression(userID, req)
+	if err != nil && key != "" {
+		h.releaseIdempotencyKey(userID, key)
+	}
+	if errors.Is(err, errInvalidExpression) {
+		writeSyntaxError(w, r, err)
 		return
 	}
-	rows, err := h.db.Query("SELECT id, expression, status, result FROM expressions WHERE user_id = ?", userID)
 	if err != nil {
-		http.Error(w, "DB error", http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.InternalError, nil)
 		return
 	}
-	defer rows.Close()
-	var expressions []models.Expression
-	for rows.Next() {
-		var expr models.Expression
-		var result sql.NullFloat64
-		if err := rows.Scan(&expr.ID, &expr.Expression, &expr.Status, &result); err == nil {
-			if result.Valid {
-				expr.Result = &result.Float64
-			}
-			expressions = append(expressions, expr)
-		}
+
+	body, _ := json.Marshal(map[string]string{"id": id})
+	body = append(body, '\n')
+	if key != "" {
+		h.saveIdempotentResponse(userID, key, http.StatusCreated, body)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": expressions})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
 }
 
 func (h *Handler) GetExpressionHandler(w http.ResponseWriter, r *http.Request) {
 	userID := GetUserID(r)
 	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, apierror.Unauthorized, nil)
 		return
 	}
 	vars := mux.Vars(r)
 	id := vars["id"]
-	var expr models.Expression
-	var result sql.NullFloat64
-	err := h.db.QueryRow("SELECT id, expression, status, result FROM expressions WHERE id = ? AND user_id = ?", id, userID).Scan(&expr.ID, &expr.Expression, &expr.Status, &result)
+	wait, err := parseWait(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "wait", "max": MaxWait.String()})
+		return
+	}
+	// Подписываемся до чтения из БД, чтобы не пропустить завершение между ними
+	var done <-chan struct{}
+	if wait > 0 {
+		var cancel func()
+		done, cancel = h.waiters.subscribe(id)
+		defer cancel()
+	}
+	// Выражение видно владельцу и пользователям, которым он выдал право чтения
+	load := func() (models.Expression, error) {
+		return h.readableExpression(id, userID)
+	}
+	expr, err := load()
+	if err == nil && wait > 0 && !terminal(expr.Status) {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-done:
+			expr, err = load()
+		case <-timer.C:
+			expr, err = load()
+		case <-r.Context().Done():
+			// Клиент ушел, ответ никому не нужен
+			return
+		}
+	}
 	if err == sql.ErrNoRows {
-		http.Error(w, "Expression not found", http.StatusNotFound)
+		apierror.Write(w, r, apierror.ExpressionNotFound, nil)
 		return
 	}
 	if err != nil {
-		http.Error(w, "DB error", http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.InternalError, nil)
 		return
 	}
-	if result.Valid {
-		expr.Result = &result.Float64
-	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"expression": expr})
 }
 
 func (h *Handler) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	select {
-	case task := <-h.taskQueue:
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"task": task,
-		})
-	default:
-		http.Error(w, "нет задач", http.StatusNotFound)
+	agentID, _ := agentauth.Identity(r.Context())
+	h.agentSeen(agentID, false)
+	task, ok := h.taskQueue.pop()
+	if !ok {
+		apierror.Write(w, r, apierror.NoTask, nil)
+		return
 	}
+	h.leaseTask(&task, agentID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"task": task,
+	})
 }
 
 func (h *Handler) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request) {
 
 	var result models.TaskResult
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
-		http.Error(w, "Ошибка декодирования результата задачи", http.StatusUnprocessableEntity)
+		apierror.Write(w, r, apierror.InvalidTaskResult, nil)
 		return
 	}
-	_, ok := h.tasks.Load(result.ID)
-	if !ok {
-		http.Error(w, "Задача не найдена", http.StatusNotFound)
+	agentID, _ := agentauth.Identity(r.Context())
+	h.agentSeen(agentID, false)
+	taskID, err := parseTaskID(result.ID)
+	if err == nil {
+		err = h.completeTask(agentID, taskID, result.Result)
+	}
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		apierror.Write(w, r, apierror.TaskNotFound, nil)
+		return
+	case errors.Is(err, errTaskNotLeased):
+		// Результат принимается только от агента, получившего задачу
+		apierror.Write(w, r, apierror.TaskNotLeased, nil)
+		return
+	case errors.Is(err, errTaskAlreadyDone):
+		apierror.Write(w, r, apierror.TaskAlreadyDone, nil)
+		return
+	case err != nil:
+		apierror.Write(w, r, apierror.InternalError, nil)
 		return
 	}
-	h.tasks.Store(result.ID, result)
-
-	expressionsCount := 0
-	pendingExpressions := 0
-	h.expressions.Range(
-		func(key, value interface{}) bool {
-			expressionsCount++
-			expr, ok := value.(*models.Expression)
-			if !ok {
-				return true
-			}
-
-			if expr.Status == models.StatusPending || expr.Status == models.StatusProcessing {
-				pendingExpressions++
-				expressionID := key.(string)
-
-				if expr.Status == models.StatusPending {
-					expr.Status = models.StatusProcessing
-					h.expressions.Store(expressionID, expr)
-				}
-
-				completedTasksCount := 0
-				taskResults := []float64{}
-				h.tasks.Range(func(taskKey, taskValue interface{}) bool {
-					taskResult, isResult := taskValue.(models.TaskResult)
-					if isResult {
-						completedTasksCount++
-						taskResults = append(taskResults, taskResult.Result)
-					}
-					return true
-				})
-
-				// Если есть хотя бы один результат, считаем выражение завершенным
-				if completedTasksCount > 0 {
-					finalResult := taskResults[len(taskResults)-1]
-					expr.Result = &finalResult
-					expr.Status = models.StatusCompleted
-					h.expressions.Store(expressionID, expr)
-				}
-			}
-			return true
-		})
-	log.Printf("Всего выражений: %d, из них ожидающих: %d", expressionsCount, pendingExpressions)
 	w.WriteHeader(http.StatusOK)
 }
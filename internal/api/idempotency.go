@@ -0,0 +1,98 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// IdempotencyKeyHeader — заголовок, с которым клиент может безопасно
+// повторить POST /api/v1/calculate: повтор с тем же ключом и телом
+// возвращает исходный ответ, а не создает выражение заново
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	MaxIdempotencyKeyLength  = 255
+)
+
+// IdempotencyTTL — сколько хранится ключ и ответ на запрос с ним
+var IdempotencyTTL = 24 * time.Hour
+
+// Ошибки повторного использования ключа
+var (
+	errIdempotencyMismatch   = errors.New("ключ идемпотентности использован с другим телом запроса")
+	errIdempotencyInProgress = errors.New("запрос с этим ключом идемпотентности еще выполняется")
+)
+
+// idempotentResponse — сохраненный ответ на первый запрос с ключом
+type idempotentResponse struct {
+	status int
+	body   []byte
+}
+
+// requestHash — хеш тела запроса после разбора, чтобы пробелы и порядок
+// полей JSON не делали повтор "другим" запросом
+func requestHash(req interface{}) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reserveIdempotencyKey закрепляет ключ за запросом. Если ключ уже
+// использован тем же запросом и ответ сохранен, возвращает этот ответ.
+func (h *Handler) reserveIdempotencyKey(userID, key, hash string) (*idempotentResponse, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE expires_at < ?", now); err != nil {
+		return nil, err
+	}
+	var storedHash string
+	var status sql.NullInt64
+	var body []byte
+	err = tx.QueryRow("SELECT request_hash, status_code, response FROM idempotency_keys WHERE user_id = ? AND key = ?",
+		userID, key).Scan(&storedHash, &status, &body)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(`INSERT INTO idempotency_keys (user_id, key, request_hash, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)`, userID, key, hash, now, now.Add(IdempotencyTTL))
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	case err != nil:
+		return nil, err
+	case storedHash != hash:
+		return nil, errIdempotencyMismatch
+	case !status.Valid:
+		return nil, errIdempotencyInProgress
+	}
+	return &idempotentResponse{status: int(status.Int64), body: body}, nil
+}
+
+// saveIdempotentResponse сохраняет ответ для повторов с тем же ключом
+func (h *Handler) saveIdempotentResponse(userID, key string, status int, body []byte) {
+	_, err := h.db.Exec("UPDATE idempotency_keys SET status_code = ?, response = ? WHERE user_id = ? AND key = ?",
+		status, body, userID, key)
+	if err != nil {
+		log.Printf("Не удалось сохранить ответ для ключа идемпотентности: %v", err)
+	}
+}
+
+// releaseIdempotencyKey освобождает ключ, если запрос не создал выражение,
+// чтобы клиент мог исправить запрос и повторить его с тем же ключом
+func (h *Handler) releaseIdempotencyKey(userID, key string) {
+	if _, err := h.db.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND status_code IS NULL", userID, key); err != nil {
+		log.Printf("Не удалось освободить ключ идемпотентности: %v", err)
+	}
+}
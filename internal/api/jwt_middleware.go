@@ -2,35 +2,136 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v4"
+	"calculator/internal/apierror"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	claimsKey contextKey = "claims"
+	scopesKey contextKey = "scopes" // задан только при входе по API ключу
+)
 
-// JWTMiddleware проверяет JWT и добавляет userID в context
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		header := r.Header.Get("Authorization")
-		if !strings.HasPrefix(header, "Bearer ") {
-			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
-			return
-		}
-		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		claims := &UserClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
+// JWTMiddleware проверяет JWT и добавляет userID в context.
+// Токены без jti, отозванные через logout, выданные до смены пароля
+// и принадлежащие удаленным аккаунтам отклоняются.
+// Вместо JWT можно передать API ключ: "Authorization: ApiKey ..." или X-API-Key.
+func JWTMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := apiKeyFromRequest(r); ok {
+				claims, scopes, err := authenticateAPIKey(db, key)
+				if ae, isAuthErr := err.(*authError); isAuthErr {
+					apierror.Write(w, r, ae.code, nil)
+					return
+				}
+				if err != nil {
+					apierror.Write(w, r, apierror.InternalError, nil)
+					return
+				}
+				ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+				ctx = context.WithValue(ctx, claimsKey, claims)
+				ctx = context.WithValue(ctx, scopesKey, scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				apierror.Write(w, r, apierror.Unauthorized, nil)
+				return
+			}
+			tokenStr := strings.TrimPrefix(header, "Bearer ")
+			claims := &UserClaims{}
+			token, err := jwtKeys.Parse(tokenStr, claims)
+			if err != nil || !token.Valid || claims.ID == "" || claims.ExpiresAt == nil {
+				apierror.Write(w, r, apierror.InvalidToken, nil)
+				return
+			}
+			revoked, err := isTokenRevoked(db, claims.ID)
+			if err != nil {
+				apierror.Write(w, r, apierror.InternalError, nil)
+				return
+			}
+			if revoked {
+				apierror.Write(w, r, apierror.TokenRevoked, nil)
+				return
+			}
+			// Токены, выданные до смены пароля, и токены удаленных аккаунтов недействительны
+			state, err := loadAuthState(db, claims.UserID)
+			if err == errUserNotFound {
+				apierror.Write(w, r, apierror.InvalidToken, nil)
+				return
+			}
+			if err != nil {
+				apierror.Write(w, r, apierror.InternalError, nil)
+				return
+			}
+			if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(state.validAfter) {
+				apierror.Write(w, r, apierror.TokenRevoked, nil)
+				return
+			}
+			if state.disabled {
+				apierror.Write(w, r, apierror.AccountDisabled, nil)
+				return
+			}
+			// Роль в токене могла устареть: админ мог ее изменить
+			claims.Role = state.role
+			ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, claimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
-		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+	}
+}
+
+// RequireRole пропускает только пользователей с указанной ролью.
+// Ставится после JWTMiddleware.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaims(r)
+			if claims == nil {
+				apierror.Write(w, r, apierror.Unauthorized, nil)
+				return
+			}
+			if claims.Role != role {
+				apierror.Write(w, r, apierror.Forbidden, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope пропускает запросы с JWT и запросы по API ключу,
+// у которого есть указанная область доступа
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, byKey := r.Context().Value(scopesKey).([]string)
+			if byKey && !hasScope(scopes, scope) {
+				apierror.Write(w, r, apierror.InsufficientScope, apierror.Details{"scope": scope})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SessionOnly пропускает только запросы с JWT: API ключом нельзя
+// управлять аккаунтом, другими ключами и администрированием
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, byKey := r.Context().Value(scopesKey).([]string); byKey {
+			apierror.Write(w, r, apierror.SessionRequired, nil)
 			return
 		}
-		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -42,3 +143,9 @@ func GetUserID(r *http.Request) string {
 	}
 	return ""
 }
+
+// GetClaims извлекает claims проверенного токена из context
+func GetClaims(r *http.Request) *UserClaims {
+	claims, _ := r.Context().Value(claimsKey).(*UserClaims)
+	return claims
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"calculator/internal/models"
+)
+
+// LoginLimiter считает неудачные входы по логину и по IP и блокирует
+// вход с экспоненциально растущей задержкой после порога неудач
+type LoginLimiter struct {
+	MaxFailures   int           // неудач по логину до первой блокировки
+	MaxIPFailures int           // неудач с одного IP до первой блокировки
+	BaseLockout   time.Duration // длительность первой блокировки
+	MaxLockout    time.Duration // верхняя граница блокировки
+	// Счетчик обнуляется, если за это время после последней неудачи
+	// и конца блокировки новых неудач не было
+	FailureWindow time.Duration
+}
+
+// LoginThrottle — действующие настройки, оркестратор может их изменить
+var LoginThrottle = LoginLimiter{
+	MaxFailures:   5,
+	MaxIPFailures: 50,
+	BaseLockout:   30 * time.Second,
+	MaxLockout:    time.Hour,
+	FailureWindow: 15 * time.Minute,
+}
+
+// lockout возвращает длительность блокировки после failures неудач
+func (l LoginLimiter) lockout(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	d := l.BaseLockout
+	for i := threshold; i < failures && d < l.MaxLockout; i++ {
+		d *= 2
+	}
+	if d > l.MaxLockout {
+		d = l.MaxLockout
+	}
+	return d
+}
+
+// retryAfter возвращает, сколько еще ждать, если какой-то из ключей заблокирован
+func (l LoginLimiter) retryAfter(db *sql.DB, keys ...string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, key := range keys {
+		var lockedUntil sql.NullTime
+		err := db.QueryRow("SELECT locked_until FROM login_failures WHERE key = ?", key).Scan(&lockedUntil)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if lockedUntil.Valid && lockedUntil.Time.After(now) {
+			if d := lockedUntil.Time.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// fail увеличивает счетчик неудач и при необходимости ставит блокировку
+func (l LoginLimiter) fail(db *sql.DB, key string, threshold int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	staleBefore := now.Add(-l.FailureWindow)
+	// Устаревшие счетчики, в том числе по IP, которые успешный вход не сбрасывает
+	_, err = tx.Exec("DELETE FROM login_failures WHERE last_failure < ?1 AND (locked_until IS NULL OR locked_until < ?1)",
+		staleBefore)
+	if err != nil {
+		return err
+	}
+
+	var failures int
+	err = tx.QueryRow("SELECT failures FROM login_failures WHERE key = ?", key).Scan(&failures)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	failures++
+	var lockedUntil interface{}
+	if d := l.lockout(failures, threshold); d > 0 {
+		lockedUntil = now.Add(d)
+	}
+	_, err = tx.Exec(`INSERT INTO login_failures (key, failures, locked_until, last_failure) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET failures = excluded.failures,
+			locked_until = excluded.locked_until, last_failure = excluded.last_failure`,
+		key, failures, lockedUntil, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reset сбрасывает счетчик после успешного входа
+func (l LoginLimiter) reset(db *sql.DB, key string) {
+	if _, err := db.Exec("DELETE FROM login_failures WHERE key = ?", key); err != nil {
+		log.Printf("Ошибка сброса счетчика неудачных входов: %v", err)
+	}
+}
+
+// loginKey и ipKey — ключи счетчиков в таблице login_failures
+func loginKey(login string) string { return "login:" + strings.ToLower(login) }
+
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// dummyHash проверяется для несуществующих логинов, чтобы время ответа
+// не выдавало, есть ли такой пользователь
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+func getDummyHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = models.HashPassword("dummy password for timing")
+	})
+	return dummyHash
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// Заголовок, заметки и метки — записная книжка пользователя поверх
+// выражения. Задаются при отправке, меняются через PATCH, ищутся через
+// GET /api/v1/expressions/search.
+
+// Ограничения метаданных выражения; длины — в символах
+var (
+	MaxTitleLength = 200
+	MaxNotesLength = 10000
+	MaxTags        = 20
+	MaxTagLength   = 32
+)
+
+// metadataError — поле метаданных не прошло проверку
+type metadataError struct {
+	parameter string
+	reason    string
+	max       int
+}
+
+func (e *metadataError) Error() string {
+	return e.parameter + ": " + e.reason
+}
+
+func (e *metadataError) details() apierror.Details {
+	d := apierror.Details{"parameter": e.parameter, "reason": e.reason}
+	if e.max > 0 {
+		d["max"] = e.max
+	}
+	return d
+}
+
+// validTag — метка из букв, цифр, '-' и '_'
+func validTag(tag string) bool {
+	for _, c := range tag {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return tag != ""
+}
+
+// validateMetadata проверяет заголовок, заметки и метки. Метки
+// возвращаются в нижнем регистре, без пробелов по краям и без повторов,
+// в порядке первого появления.
+func validateMetadata(title, notes string, tags []string) ([]string, error) {
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return nil, &metadataError{parameter: "title", reason: "too long", max: MaxTitleLength}
+	}
+	if utf8.RuneCountInString(notes) > MaxNotesLength {
+		return nil, &metadataError{parameter: "notes", reason: "too long", max: MaxNotesLength}
+	}
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, &metadataError{parameter: "tags", reason: "too long", max: MaxTagLength}
+		}
+		if !validTag(tag) {
+			return nil, &metadataError{parameter: "tags", reason: "invalid"}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxTags {
+		return nil, &metadataError{parameter: "tags", reason: "too many", max: MaxTags}
+	}
+	return normalized, nil
+}
+
+// tagsColumn — значение колонки tags: JSON массив или NULL
+func tagsColumn(tags []string) (interface{}, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// applyMetadataPatch применяет JSON merge patch к метаданным выражения:
+// ref, title, notes и tags; null очищает поле, остальные поля только
+// для чтения
+func applyMetadataPatch(expr *models.Expression, patch map[string]json.RawMessage) error {
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		raw := patch[field]
+		switch field {
+		case "ref", "title", "notes":
+			var value *string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return &metadataError{parameter: field, reason: "invalid"}
+			}
+			if value == nil {
+				value = new(string)
+			}
+			switch field {
+			case "ref":
+				expr.Ref = *value
+			case "title":
+				expr.Title = *value
+			case "notes":
+				expr.Notes = *value
+			}
+		case "tags":
+			var tags []string
+			if err := json.Unmarshal(raw, &tags); err != nil {
+				return &metadataError{parameter: field, reason: "invalid"}
+			}
+			expr.Tags = tags
+		default:
+			return &metadataError{parameter: field, reason: "read-only"}
+		}
+	}
+	tags, err := validateMetadata(expr.Title, expr.Notes, expr.Tags)
+	if err != nil {
+		return err
+	}
+	expr.Tags = tags
+	return nil
+}
+
+// errPreconditionFailed — выражение изменилось с момента чтения клиентом
+var errPreconditionFailed = errors.New("precondition failed")
+
+// saveMetadata записывает метаданные выражения владельца
+func saveMetadata(db execer, expr models.Expression) error {
+	tags, err := tagsColumn(expr.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE expressions SET client_ref = ?, title = ?, notes = ?, tags = ? WHERE id = ? AND user_id = ?",
+		nullString(expr.Ref), nullString(expr.Title), nullString(expr.Notes), tags, expr.ID, expr.UserID)
+	return err
+}
+
+// patchMetadata применяет patch к выражению владельца. Чтение, проверка
+// precondition и запись идут в одной транзакции, поэтому If-Match
+// сравнивается с тем, что и будет изменено. Чужое выражение —
+// sql.ErrNoRows, precondition вернул false — errPreconditionFailed.
+func (h *Handler) patchMetadata(id, userID string, patch map[string]json.RawMessage, precondition func(models.Expression) bool) (models.Expression, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return models.Expression{}, err
+	}
+	defer tx.Rollback()
+	expr, err := scanExpression(tx.QueryRow("SELECT "+expressionColumns+" FROM expressions e WHERE e.id = ? AND e.user_id = ?", id, userID))
+	if err != nil {
+		return expr, err
+	}
+	if precondition != nil && !precondition(expr) {
+		return expr, errPreconditionFailed
+	}
+	if err := applyMetadataPatch(&expr, patch); err != nil {
+		return expr, err
+	}
+	if err := saveMetadata(tx, expr); err != nil {
+		return expr, err
+	}
+	return expr, tx.Commit()
+}
+
+// writePatchError отвечает на ошибку patchMetadata
+func writePatchError(w http.ResponseWriter, r *http.Request, err error) {
+	var metaErr *metadataError
+	switch {
+	case err == sql.ErrNoRows:
+		// Чужое выражение, выданное на чтение, изменять нельзя
+		apierror.Write(w, r, apierror.ExpressionNotFound, nil)
+	case err == errPreconditionFailed:
+		apierror.Write(w, r, apierror.PreconditionFailed, nil)
+	case errors.As(err, &metaErr):
+		apierror.Write(w, r, apierror.InvalidParameter, metaErr.details())
+	default:
+		apierror.Write(w, r, apierror.InternalError, nil)
+	}
+}
+
+// PatchExpressionHandler — PATCH /api/v1/expressions/{id}: изменение
+// ref, title, notes и tags владельцем. Тело — JSON merge patch.
+func (h *Handler) PatchExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	expr, err := h.patchMetadata(mux.Vars(r)["id"], userID, patch, nil)
+	if err != nil {
+		writePatchError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"expression": expr})
+}
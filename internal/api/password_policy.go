@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"calculator/internal/apierror"
+)
+
+// PasswordPolicy — требования к паролю при регистрации и смене пароля
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	Denylist  map[string]bool // пароли в нижнем регистре
+}
+
+// commonPasswords — самые частые пароли из публичных утечек
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "12345", "1234567", "1234567890",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "111111", "000000", "123123", "abc123", "iloveyou",
+	"admin", "admin123", "welcome", "welcome1", "letmein", "monkey",
+	"dragon", "football", "baseball", "sunshine", "princess", "master",
+	"superman", "trustno1", "1q2w3e4r", "1qaz2wsx", "zaq12wsx", "asdfghjkl",
+	"changeme", "secret", "login", "test1234", "calculator", "йцукен",
+}
+
+// DefaultPasswordPolicy возвращает политику по умолчанию
+func DefaultPasswordPolicy() PasswordPolicy {
+	p := PasswordPolicy{MinLength: 8, MaxLength: 128, Denylist: make(map[string]bool)}
+	for _, pw := range commonPasswords {
+		p.Denylist[pw] = true
+	}
+	return p
+}
+
+// Policy — действующая политика паролей, оркестратор может ее изменить
+var Policy = DefaultPasswordPolicy()
+
+// LoadDenylist добавляет в политику пароли из файла, по одному на строку
+func (p *PasswordPolicy) LoadDenylist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("password denylist: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pw := strings.TrimSpace(scanner.Text()); pw != "" {
+			p.Denylist[strings.ToLower(pw)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// PolicyError — пароль не подходит под политику
+type PolicyError struct {
+	Code    apierror.Code
+	Details apierror.Details
+	msg     string
+}
+
+func (e *PolicyError) Error() string { return e.msg }
+
+// Check возвращает *PolicyError с понятным пользователю текстом,
+// если пароль не подходит под политику
+func (p PasswordPolicy) Check(login, password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < p.MinLength {
+		return &PolicyError{apierror.PasswordTooShort, apierror.Details{"min_length": p.MinLength},
+			fmt.Sprintf("password must be at least %d characters", p.MinLength)}
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		return &PolicyError{apierror.PasswordTooLong, apierror.Details{"max_length": p.MaxLength},
+			fmt.Sprintf("password must be at most %d characters", p.MaxLength)}
+	}
+	lower := strings.ToLower(password)
+	if p.Denylist[lower] {
+		return &PolicyError{apierror.PasswordTooCommon, nil, "password is too common"}
+	}
+	if login != "" && lower == strings.ToLower(login) {
+		return &PolicyError{apierror.PasswordMatchesLogin, nil, "password must not match the login"}
+	}
+	return nil
+}
+
+// writePolicyError отвечает кодом нарушенного правила политики паролей
+func writePolicyError(w http.ResponseWriter, r *http.Request, err error) {
+	var pe *PolicyError
+	if !errors.As(err, &pe) {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	apierror.Write(w, r, pe.Code, pe.Details)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"calculator/internal/apierror"
+	"calculator/internal/calculator"
+	"calculator/internal/models"
+)
+
+// PlanArg — аргумент узла плана: число или результат другого узла
+type PlanArg struct {
+	Value *float64 `json:"value,omitempty"`
+	Node  *int     `json:"node,omitempty"`
+}
+
+// PlanNode — будущая задача агента. StartMS и FinishMS — самое раннее
+// начало и конец при неограниченном числе агентов.
+type PlanNode struct {
+	ID            int     `json:"id"`
+	Operator      string  `json:"operator"`
+	Arg1          PlanArg `json:"arg1"`
+	Arg2          PlanArg `json:"arg2"`
+	OperationTime int64   `json:"operation_time"`
+	StartMS       int64   `json:"start_ms"`
+	FinishMS      int64   `json:"finish_ms"`
+}
+
+// PlanEdge — результат узла From становится аргументом Arg узла To
+type PlanEdge struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Arg  string `json:"arg"` // arg1 или arg2
+}
+
+// Plan — что оркестратор сделает с выражением
+type Plan struct {
+	Expression     string           `json:"expression"`
+	Normalized     string           `json:"normalized"`
+	AST            *calculator.Node `json:"ast"`
+	Nodes          []PlanNode       `json:"nodes"`
+	Edges          []PlanEdge       `json:"edges"`
+	TaskCount      int              `json:"task_count"`
+	CriticalPathMS int64            `json:"critical_path_ms"` // время вычисления при неограниченном числе агентов
+	MaxParallelism int              `json:"max_parallelism"`  // наибольшее число задач, выполняемых одновременно
+	Value          *float64         `json:"value,omitempty"`  // значение выражения без операций
+}
+
+// PlanHandler — POST /api/v1/expressions/plan: разбирает выражение, ничего
+// не сохраняя, и возвращает граф задач с оценкой времени. Ошибки разбора —
+// те же, что у POST /api/v1/calculate.
+func (h *Handler) PlanHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.CalculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	item, err := compileExpression(req)
+	if err != nil {
+		writeSyntaxError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"plan": h.plan(item)})
+}
+
+// plan строит граф задач выражения по тем же шагам, что createExpressions
+func (h *Handler) plan(item compiledExpression) Plan {
+	p := Plan{
+		Expression: item.req.Expression,
+		Normalized: item.tree.String(),
+		AST:        item.tree,
+		Nodes:      make([]PlanNode, len(item.steps)),
+		Edges:      []PlanEdge{},
+		TaskCount:  len(item.steps),
+	}
+	if len(item.steps) == 0 {
+		p.Value = &item.value
+		return p
+	}
+	for i, step := range item.steps {
+		node := PlanNode{ID: i, Operator: step.Operator, OperationTime: h.operationTime(step.Operator)}
+		for _, a := range []struct {
+			arg  calculator.Arg
+			dst  *PlanArg
+			name string
+		}{{step.Arg1, &node.Arg1, "arg1"}, {step.Arg2, &node.Arg2, "arg2"}} {
+			if a.arg.Step < 0 {
+				v := a.arg.Value
+				a.dst.Value = &v
+				continue
+			}
+			from := a.arg.Step
+			a.dst.Node = &from
+			p.Edges = append(p.Edges, PlanEdge{From: from, To: i, Arg: a.name})
+			// Источник всегда раньше в списке шагов, его время уже известно
+			if finish := p.Nodes[from].FinishMS; finish > node.StartMS {
+				node.StartMS = finish
+			}
+		}
+		node.FinishMS = node.StartMS + node.OperationTime
+		if node.FinishMS > p.CriticalPathMS {
+			p.CriticalPathMS = node.FinishMS
+		}
+		p.Nodes[i] = node
+	}
+	p.MaxParallelism = maxParallelism(p.Nodes)
+	return p
+}
+
+// maxParallelism — наибольшее число пересекающихся интервалов
+// [StartMS, FinishMS); задача, которая заканчивается в момент начала
+// другой, с ней не пересекается
+func maxParallelism(nodes []PlanNode) int {
+	type point struct {
+		at    int64
+		delta int
+	}
+	points := make([]point, 0, 2*len(nodes))
+	for _, n := range nodes {
+		points = append(points, point{n.StartMS, 1}, point{n.FinishMS, -1})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].at != points[j].at {
+			return points[i].at < points[j].at
+		}
+		return points[i].delta < points[j].delta
+	})
+	running, best := 0, 0
+	for _, pt := range points {
+		running += pt.delta
+		if running > best {
+			best = running
+		}
+	}
+	return best
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"calculator/internal/models"
+)
+
+// TaskLeaseTimeout — сколько сверх времени операции агент может держать
+// задачу. Задача без результата после этого снова ставится в очередь:
+// агент мог упасть, не вернув результат.
+var TaskLeaseTimeout = 5 * time.Minute
+
+// LeaseCheckInterval — как часто RunLeaseReaper ищет просроченные задачи
+var LeaseCheckInterval = 30 * time.Second
+
+// taskQueue — очередь готовых к выдаче задач. Без ограничения размера:
+// пакет выражений или завершение задачи с множеством зависимых ставит
+// в очередь сразу много задач, и запись не должна ждать агентов.
+type taskQueue struct {
+	mu    sync.Mutex
+	tasks []models.Task
+}
+
+func (q *taskQueue) push(tasks ...models.Task) {
+	q.mu.Lock()
+	q.tasks = append(q.tasks, tasks...)
+	q.mu.Unlock()
+}
+
+func (q *taskQueue) pop() (models.Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tasks) == 0 {
+		return models.Task{}, false
+	}
+	task := q.tasks[0]
+	q.tasks[0] = models.Task{}
+	q.tasks = q.tasks[1:]
+	return task, true
+}
+
+func (q *taskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tasks)
+}
+
+// readyTasks выбирает задачи незавершенных выражений, у которых готовы оба
+// аргумента и нет результата; cond сужает выборку по выдаче агенту
+func readyTasks(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, cond string, args ...interface{}) ([]models.Task, []time.Time, error) {
+	rows, err := q.Query(`SELECT t.id, t.operation, t.arg1, t.arg2, t.operation_time, t.leased_at FROM tasks t
+		JOIN expressions e ON e.id = t.expression_id
+		WHERE t.completed_at IS NULL AND e.status IN (?, ?) AND `+cond+` AND NOT EXISTS (
+			SELECT 1 FROM tasks d WHERE d.id IN (t.arg1_task, t.arg2_task) AND d.completed_at IS NULL)
+		ORDER BY t.id`, append([]interface{}{models.StatusPending, models.StatusProcessing}, args...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var tasks []models.Task
+	var leasedAt []time.Time
+	for rows.Next() {
+		var task models.Task
+		var id int64
+		var leased sql.NullTime
+		if err := rows.Scan(&id, &task.Operation, &task.Arg1, &task.Arg2, &task.OperationTime, &leased); err != nil {
+			return nil, nil, err
+		}
+		task.ID = strconv.FormatInt(id, 10)
+		tasks = append(tasks, task)
+		leasedAt = append(leasedAt, leased.Time)
+	}
+	return tasks, leasedAt, rows.Err()
+}
+
+// restoreQueue заполняет очередь после перезапуска: очередь живет только
+// в памяти, а готовые задачи, не выданные агентам, остаются в таблице tasks
+func (h *Handler) restoreQueue() {
+	if h.db == nil {
+		return
+	}
+	tasks, _, err := readyTasks(h.db, "t.leased_at IS NULL")
+	if err != nil {
+		log.Printf("Не удалось восстановить очередь задач: %v", err)
+		return
+	}
+	h.taskQueue.push(tasks...)
+	if len(tasks) > 0 {
+		log.Printf("Восстановлено задач в очереди: %d", len(tasks))
+	}
+}
+
+// RequeueExpiredLeases возвращает в очередь задачи, выданные агентам
+// дольше operation_time + TaskLeaseTimeout назад, и возвращает их число.
+// Результат от прежнего агента после этого не принимается.
+func (h *Handler) RequeueExpiredLeases() (int, error) {
+	now := time.Now().UTC()
+	tasks, leasedAt, err := readyTasks(h.db, "t.leased_at IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	var expired []models.Task
+	for i, task := range tasks {
+		deadline := leasedAt[i].Add(time.Duration(task.OperationTime)*time.Millisecond + TaskLeaseTimeout)
+		if now.Before(deadline) {
+			continue
+		}
+		// leased_at в условии: задачу могли успеть завершить или выдать заново
+		res, err := h.db.Exec(`UPDATE tasks SET leased_at = NULL, agent_id = NULL, enqueued_at = ?
+			WHERE id = ? AND leased_at = ? AND completed_at IS NULL`, now, task.ID, leasedAt[i])
+		if err != nil {
+			return len(expired), err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			log.Printf("Задача %s не вернулась от агента за отведенное время, снова в очереди", task.ID)
+			expired = append(expired, task)
+		}
+	}
+	h.taskQueue.push(expired...)
+	return len(expired), nil
+}
+
+// RunLeaseReaper периодически возвращает в очередь просроченные задачи,
+// пока не отменен ctx
+func (h *Handler) RunLeaseReaper(ctx context.Context) {
+	ticker := time.NewTicker(LeaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.RequeueExpiredLeases(); err != nil {
+				log.Printf("Не удалось вернуть просроченные задачи в очередь: %v", err)
+			}
+		}
+	}
+}
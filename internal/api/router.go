@@ -0,0 +1,118 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"calculator/internal/agentauth"
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"calculator/internal/openapi"
+	"github.com/gorilla/mux"
+)
+
+// NewRouter собирает маршруты HTTP API оркестратора. Тесты строят сервер
+// этой же функцией, чтобы проверять настоящие маршруты и middleware.
+func NewRouter(db *sql.DB, handler *Handler, agentTokens *agentauth.Tokens) *mux.Router {
+	r := mux.NewRouter()
+	// Неизвестный путь и неподдерживаемый метод — тоже в едином формате ошибок
+	r.NotFoundHandler = http.HandlerFunc(apierror.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(apierror.MethodNotAllowedHandler)
+
+	// Публичные ключи для проверки наших JWT другими сервисами
+	r.HandleFunc("/.well-known/jwks.json", JWKSHandler).Methods("GET")
+
+	// Регистрация и логин
+	r.HandleFunc("/api/v1/register", RegisterHandler(db)).Methods("POST")
+	r.HandleFunc("/api/v1/login", LoginHandler(db)).Methods("POST")
+	r.HandleFunc("/api/v1/token/refresh", RefreshHandler(db)).Methods("POST")
+
+	// Публичные API endpoints (требуют JWT или API ключ)
+	requireJWT := JWTMiddleware(db)
+	// session — только JWT: API ключом нельзя управлять аккаунтом и ключами
+	session := func(h http.Handler) http.Handler { return requireJWT(SessionOnly(h)) }
+	canCalculate := func(h http.HandlerFunc) http.Handler { return requireJWT(RequireScope(ScopeCalculate)(h)) }
+	canRead := func(h http.HandlerFunc) http.Handler { return requireJWT(RequireScope(ScopeRead)(h)) }
+	r.Handle("/api/v1/logout", session(LogoutHandler(db))).Methods("POST", "OPTIONS")
+
+	// Управление аккаунтом
+	r.Handle("/api/v1/me", session(MeHandler(db))).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/me", session(DeleteAccountHandler(db))).Methods("DELETE")
+	r.Handle("/api/v1/me/password", session(ChangePasswordHandler(db))).Methods("PUT")
+	r.Handle("/api/v1/me/login", session(ChangeLoginHandler(db))).Methods("PUT")
+
+	// Персональные API ключи
+	r.Handle("/api/v1/api-keys", session(CreateAPIKeyHandler(db))).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/api-keys", session(ListAPIKeysHandler(db))).Methods("GET")
+	r.Handle("/api/v1/api-keys/{id}", session(RevokeAPIKeyHandler(db))).Methods("DELETE", "OPTIONS")
+
+	// Вебхуки о завершении выражений
+	r.Handle("/api/v1/webhooks", session(http.HandlerFunc(handler.CreateWebhookHandler))).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/webhooks", session(http.HandlerFunc(handler.ListWebhooksHandler))).Methods("GET")
+	r.Handle("/api/v1/webhooks/{id}", session(http.HandlerFunc(handler.DeleteWebhookHandler))).Methods("DELETE", "OPTIONS")
+	r.Handle("/api/v1/webhooks/{id}/deliveries", session(http.HandlerFunc(handler.ListWebhookDeliveriesHandler))).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/webhooks/{id}/deliveries/{delivery}/redeliver", session(http.HandlerFunc(handler.RedeliverWebhookHandler))).Methods("POST", "OPTIONS")
+
+	r.Handle("/api/v1/calculate", canCalculate(handler.CalculateHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/evaluate", canCalculate(handler.EvaluateHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/calculate/batch", canCalculate(handler.CalculateBatchHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/batches/{id}", canRead(handler.GetBatchHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/expressions", canRead(handler.GetExpressionsHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/expressions/plan", canRead(handler.PlanHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/expressions/shared-with-me", canRead(handler.SharedWithMeHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/expressions/search", canRead(handler.SearchExpressionsHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/expressions/{id}", canRead(handler.GetExpressionHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/expressions/{id}", canCalculate(handler.PatchExpressionHandler)).Methods("PATCH")
+	r.Handle("/api/v1/expressions/{id}/timeline", canRead(handler.TimelineHandler)).Methods("GET", "OPTIONS")
+	r.Handle("/api/v1/events", canRead(handler.EventsHandler)).Methods("GET", "OPTIONS")
+
+	// API v2: те же выражения в виде ресурсов calculations, с ETag и Location
+	v2 := r.PathPrefix(V2Prefix).Subrouter()
+	v2.Handle("/calculations", canCalculate(handler.CreateCalculationHandler)).Methods("POST", "OPTIONS")
+	v2.Handle("/calculations", canRead(handler.ListCalculationsHandler)).Methods("GET")
+	v2.Handle("/calculations/{id}", canRead(handler.GetCalculationHandler)).Methods("GET", "OPTIONS")
+	v2.Handle("/calculations/{id}", canCalculate(handler.PatchCalculationHandler)).Methods("PATCH")
+	v2.Handle("/calculations/{id}/tasks", canRead(handler.CalculationTasksHandler)).Methods("GET", "OPTIONS")
+
+	// WebSocket: отправка выражений и их результаты; JWT можно передать подпротоколом
+	r.Handle("/api/v1/ws", WebSocketTokenMiddleware(
+		canCalculate(RequireScope(ScopeRead)(http.HandlerFunc(handler.WebSocketHandler)).ServeHTTP))).Methods("GET")
+
+	// Совместный доступ к выражениям: права чтения и публичные ссылки
+	sharing := func(h http.HandlerFunc) http.Handler { return session(h) }
+	r.Handle("/api/v1/expressions/{id}/grants", sharing(handler.CreateGrantHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/expressions/{id}/grants", sharing(handler.ListGrantsHandler)).Methods("GET")
+	r.Handle("/api/v1/expressions/{id}/grants/{login}", sharing(handler.RevokeGrantHandler)).Methods("DELETE", "OPTIONS")
+	r.Handle("/api/v1/expressions/{id}/links", sharing(handler.CreateShareLinkHandler)).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/expressions/{id}/links", sharing(handler.ListShareLinksHandler)).Methods("GET")
+	r.Handle("/api/v1/expressions/{id}/links/{link}", sharing(handler.RevokeShareLinkHandler)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/api/v1/shared/{token}", handler.SharedExpressionHandler).Methods("GET", "OPTIONS")
+
+	// Администрирование (требует роль admin)
+	admin := r.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(requireJWT, SessionOnly, RequireRole(models.RoleAdmin))
+	admin.HandleFunc("/users", handler.AdminListUsersHandler).Methods("GET")
+	admin.HandleFunc("/users/{id}", handler.AdminUpdateUserHandler).Methods("PATCH")
+	admin.HandleFunc("/expressions", handler.AdminListExpressionsHandler).Methods("GET")
+	admin.HandleFunc("/queue", handler.AdminQueueHandler).Methods("GET")
+	admin.HandleFunc("/agents", handler.AdminAgentsHandler).Methods("GET")
+	admin.HandleFunc("/operation-costs", handler.AdminGetOperationCostsHandler).Methods("GET")
+	admin.HandleFunc("/operation-costs", handler.AdminSetOperationCostsHandler).Methods("PUT")
+
+	// Внутренние API endpoints для агентов (требуют токен агента)
+	agentAPI := r.PathPrefix("/internal").Subrouter()
+	agentAPI.Use(agentTokens.Middleware)
+	agentAPI.HandleFunc("/task", handler.GetTaskHandler).Methods("GET")
+	agentAPI.HandleFunc("/task", handler.SubmitTaskResultHandler).Methods("POST")
+
+	// Описание API и страница документации
+	r.HandleFunc("/api/openapi.json", openapi.SpecHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/docs", openapi.DocsHandler).Methods("GET")
+
+	// Обработчик для калькулятора на корневом пути
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "calculator.html")
+	}).Methods("GET")
+
+	return r
+}
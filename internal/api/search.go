@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+)
+
+// searchQuery — разобранные параметры GET /api/v1/expressions/search
+type searchQuery struct {
+	terms  []string // слова из q
+	tags   []string // все метки должны быть у выражения
+	limit  int
+	offset int
+}
+
+// Режимы поиска в поле mode ответа
+const (
+	SearchModeFullText = "fts"  // индекс FTS5, сборка с тегом sqlite_fts5
+	SearchModeLike     = "like" // подстрока через LIKE
+)
+
+// TagFacet — метка и число найденных выражений с ней
+type TagFacet struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// searchTerms делит q на слова так же, как токенизатор unicode61:
+// разделитель — все, кроме букв и цифр. Регистр не меняется: FTS5
+// приводит слова сам, а LIKE без учета регистра сравнивает только латиницу.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(q, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// parseSearchQuery проверяет параметры поиска: нужен q или tag
+func parseSearchQuery(r *http.Request) (*searchQuery, error) {
+	v := r.URL.Query()
+	sq := &searchQuery{limit: DefaultPageSize, terms: searchTerms(v.Get("q"))}
+	if strings.TrimSpace(v.Get("q")) != "" && len(sq.terms) == 0 {
+		return nil, errors.New("q must contain letters or digits")
+	}
+	for _, tag := range v["tag"] {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validTag(tag) {
+			return nil, errors.New("invalid tag: " + tag)
+		}
+		sq.tags = append(sq.tags, tag)
+	}
+	if len(sq.terms) == 0 && len(sq.tags) == 0 {
+		return nil, errors.New("q or tag is required")
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return nil, errors.New("limit must be between 1 and " + strconv.Itoa(MaxPageSize))
+		}
+		sq.limit = n
+	}
+	if s := v.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, errors.New("offset must be a non-negative integer")
+		}
+		sq.offset = n
+	}
+	return sq, nil
+}
+
+// matchExpression — запрос FTS5: каждое слово в кавычках как префикс,
+// все слова обязательны. Кавычки и операторы из q сюда не попадают.
+func (sq *searchQuery) matchExpression() string {
+	parts := make([]string, len(sq.terms))
+	for i, term := range sq.terms {
+		parts[i] = `"` + term + `"*`
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// from — таблицы и условия поиска по выражениям пользователя. С FTS5
+// слова ищутся по индексу expressions_fts, без него — подстрокой через LIKE.
+func (sq *searchQuery) from(userID string, fullText bool) (string, string, []interface{}) {
+	from := "expressions e"
+	conds := []string{"e.user_id = ?"}
+	args := []interface{}{userID}
+	if len(sq.terms) > 0 && fullText {
+		from += " JOIN expressions_fts ON expressions_fts.rowid = e.rowid"
+		conds = append(conds, "expressions_fts MATCH ?")
+		args = append(args, sq.matchExpression())
+	} else {
+		for _, term := range sq.terms {
+			conds = append(conds, `(e.expression LIKE ? ESCAPE '\' OR e.title LIKE ? ESCAPE '\' OR e.notes LIKE ? ESCAPE '\')`)
+			pattern := "%" + escapeLike(term) + "%"
+			args = append(args, pattern, pattern, pattern)
+		}
+	}
+	for _, tag := range sq.tags {
+		conds = append(conds, "EXISTS (SELECT 1 FROM json_each(e.tags) WHERE value = ?)")
+		args = append(args, tag)
+	}
+	return from, strings.Join(conds, " AND "), args
+}
+
+// orderBy — сначала релевантные: совпадение в заголовке весит больше,
+// чем в заметках и тексте выражения. Без FTS5 — сначала новые.
+func (sq *searchQuery) orderBy(fullText bool) string {
+	if len(sq.terms) > 0 && fullText {
+		return "bm25(expressions_fts, 2.0, 10.0, 1.0), e.created_at DESC, e.id DESC"
+	}
+	return "e.created_at DESC, e.id DESC"
+}
+
+// searchExpressions — страница найденных выражений, их общее число
+// и метки найденных выражений по убыванию частоты
+func (h *Handler) searchExpressions(userID string, sq *searchQuery) ([]models.Expression, int, []TagFacet, error) {
+	from, where, args := sq.from(userID, h.fullText)
+	var total int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM "+from+" WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, nil, err
+	}
+
+	rows, err := h.db.Query("SELECT "+expressionColumns+" FROM "+from+" WHERE "+where+
+		" ORDER BY "+sq.orderBy(h.fullText)+" LIMIT ? OFFSET ?", append(args, sq.limit, sq.offset)...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rows.Close()
+	expressions := []models.Expression{}
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		expressions = append(expressions, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, nil, err
+	}
+
+	// Фасеты считаются по всем найденным выражениям, а не по странице
+	facetRows, err := h.db.Query("SELECT t.value, COUNT(*) FROM "+from+" JOIN json_each(e.tags) t WHERE "+where+
+		" GROUP BY t.value ORDER BY COUNT(*) DESC, t.value", args...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer facetRows.Close()
+	facets := []TagFacet{}
+	for facetRows.Next() {
+		var f TagFacet
+		if err := facetRows.Scan(&f.Tag, &f.Count); err != nil {
+			return nil, 0, nil, err
+		}
+		facets = append(facets, f)
+	}
+	return expressions, total, facets, facetRows.Err()
+}
+
+// SearchExpressionsHandler — GET /api/v1/expressions/search: поиск по
+// тексту, заголовку и заметкам своих выражений. Параметры: q, tag
+// (можно несколько), limit, offset. mode в ответе — каким способом
+// искались слова: от него зависят порядок и учет регистра.
+func (h *Handler) SearchExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	sq, err := parseSearchQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"reason": err.Error()})
+		return
+	}
+	expressions, total, facets, err := h.searchExpressions(userID, sq)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	mode := SearchModeLike
+	if h.fullText {
+		mode = SearchModeFullText
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expressions": expressions,
+		"total":       total,
+		"facets":      map[string]interface{}{"tags": facets},
+		"mode":        mode,
+	})
+}
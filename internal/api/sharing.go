@@ -0,0 +1,318 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ExpressionGrant — право другого пользователя читать выражение
+type ExpressionGrant struct {
+	ExpressionID string    `json:"expression_id"`
+	Login        string    `json:"login"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ShareLink — публичная ссылка на выражение; токен виден только при создании
+type ShareLink struct {
+	ID           string     `json:"id"`
+	ExpressionID string     `json:"expression_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// sharedExpression — все, что видно по публичной ссылке
+type sharedExpression struct {
+	Expression string                   `json:"expression"`
+	Status     models.CalculationStatus `json:"status"`
+	Result     *float64                 `json:"result,omitempty"`
+}
+
+// newShareToken генерирует неугадываемый токен ссылки и его хеш для БД
+func newShareToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// requireOwner проверяет, что выражение из пути принадлежит текущему
+// пользователю. Чужие выражения неотличимы от несуществующих. При ошибке
+// ответ уже записан.
+func (h *Handler) requireOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["id"]
+	var n int
+	err := h.db.QueryRow("SELECT COUNT(*) FROM expressions WHERE id = ? AND user_id = ?", id, GetUserID(r)).Scan(&n)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return "", false
+	}
+	if n == 0 {
+		apierror.Write(w, r, apierror.ExpressionNotFound, nil)
+		return "", false
+	}
+	return id, true
+}
+
+// canReadExpression — владелец или пользователь с выданным правом чтения
+const canReadExpression = `(e.user_id = ? OR EXISTS (
+	SELECT 1 FROM expression_grants g WHERE g.expression_id = e.id AND g.user_id = ?))`
+
+// visibleTo — выражение так, как его видит userID. Читателю по выданному
+// праву видны текст, статус, ход вычисления и результат; владелец,
+// переменные и записная книжка владельца (ref, title, notes, tags,
+// batch_id) — только самому владельцу.
+func visibleTo(expr models.Expression, userID string) models.Expression {
+	if expr.UserID == userID {
+		return expr
+	}
+	return models.Expression{
+		ID:          expr.ID,
+		Expression:  expr.Expression,
+		Status:      expr.Status,
+		Result:      expr.Result,
+		CreatedAt:   expr.CreatedAt,
+		StartedAt:   expr.StartedAt,
+		CompletedAt: expr.CompletedAt,
+		TaskCount:   expr.TaskCount,
+		TasksDone:   expr.TasksDone,
+		ComputeMS:   expr.ComputeMS,
+		Error:       expr.Error,
+	}
+}
+
+// CreateGrantHandler — выдать другому пользователю право читать выражение
+func (h *Handler) CreateGrantHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Login) == "" {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "login"})
+		return
+	}
+	grant := ExpressionGrant{ExpressionID: id, Login: strings.TrimSpace(req.Login), CreatedAt: time.Now().UTC()}
+	var granteeID string
+	err := h.db.QueryRow("SELECT id FROM users WHERE login = ?", grant.Login).Scan(&granteeID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.UserNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if granteeID == GetUserID(r) {
+		apierror.Write(w, r, apierror.CannotShareWithSelf, nil)
+		return
+	}
+	_, err = h.db.Exec(`INSERT INTO expression_grants (expression_id, user_id, granted_by, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(expression_id, user_id) DO NOTHING`, id, granteeID, GetUserID(r), grant.CreatedAt)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+// ListGrantsHandler — кому выдано право читать выражение
+func (h *Handler) ListGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	rows, err := h.db.Query(`SELECT g.expression_id, u.login, g.created_at FROM expression_grants g
+		JOIN users u ON u.id = g.user_id WHERE g.expression_id = ? ORDER BY g.created_at`, id)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	grants := []ExpressionGrant{}
+	for rows.Next() {
+		var g ExpressionGrant
+		if err := rows.Scan(&g.ExpressionID, &g.Login, &g.CreatedAt); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		grants = append(grants, g)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"grants": grants})
+}
+
+// RevokeGrantHandler — отозвать право чтения у пользователя
+func (h *Handler) RevokeGrantHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.db.Exec(`DELETE FROM expression_grants WHERE expression_id = ?
+		AND user_id = (SELECT id FROM users WHERE login = ?)`, id, mux.Vars(r)["login"])
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.GrantNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SharedWithMeHandler — выражения других пользователей, доступные текущему
+func (h *Handler) SharedWithMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	rows, err := h.db.Query("SELECT "+expressionColumns+` FROM expressions e
+		JOIN expression_grants g ON g.expression_id = e.id WHERE g.user_id = ? ORDER BY g.created_at`, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	expressions := []models.Expression{}
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		expressions = append(expressions, visibleTo(expr, userID))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": expressions})
+}
+
+// CreateShareLinkHandler — создать публичную ссылку на выражение.
+// Токен ссылки возвращается только в этом ответе.
+func (h *Handler) CreateShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ExpiresIn string `json:"expires_in"` // например "168h"; пусто — бессрочная
+	}
+	// Тело необязательно
+	json.NewDecoder(r.Body).Decode(&req)
+
+	link := ShareLink{ID: uuid.New().String(), ExpressionID: id, CreatedAt: time.Now().UTC()}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "expires_in"})
+			return
+		}
+		t := link.CreatedAt.Add(d)
+		link.ExpiresAt = &t
+	}
+	token, hash, err := newShareToken()
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	_, err = h.db.Exec(`INSERT INTO share_links (id, expression_id, user_id, token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, link.ID, id, GetUserID(r), hash, link.CreatedAt, link.ExpiresAt)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ShareLink
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{link, token, "/api/v1/shared/" + token})
+}
+
+// ListShareLinksHandler — ссылки на выражение, включая отозванные
+func (h *Handler) ListShareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	rows, err := h.db.Query(`SELECT id, expression_id, created_at, expires_at, revoked_at
+		FROM share_links WHERE expression_id = ? ORDER BY created_at`, id)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	links := []ShareLink{}
+	for rows.Next() {
+		var l ShareLink
+		var expiresAt, revokedAt sql.NullTime
+		if err := rows.Scan(&l.ID, &l.ExpressionID, &l.CreatedAt, &expiresAt, &revokedAt); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		l.ExpiresAt = nullTimePtr(expiresAt)
+		l.RevokedAt = nullTimePtr(revokedAt)
+		links = append(links, l)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"links": links})
+}
+
+// RevokeShareLinkHandler — отозвать публичную ссылку
+func (h *Handler) RevokeShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.db.Exec("UPDATE share_links SET revoked_at = ? WHERE id = ? AND expression_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), mux.Vars(r)["link"], id)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.LinkNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SharedExpressionHandler — выражение по публичной ссылке, без аутентификации.
+// Отдаются только текст выражения, статус и результат.
+func (h *Handler) SharedExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	var expr sharedExpression
+	var result sql.NullFloat64
+	var expiresAt, revokedAt sql.NullTime
+	err := h.db.QueryRow(`SELECT e.expression, e.status, e.result, l.expires_at, l.revoked_at
+		FROM share_links l JOIN expressions e ON e.id = l.expression_id WHERE l.token_hash = ?`,
+		hashToken(mux.Vars(r)["token"])).Scan(&expr.Expression, &expr.Status, &result, &expiresAt, &revokedAt)
+	if err != nil && err != sql.ErrNoRows {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	// Отозванная и истекшая ссылки неотличимы от несуществующей
+	if err == sql.ErrNoRows || revokedAt.Valid || (expiresAt.Valid && time.Now().UTC().After(expiresAt.Time)) {
+		apierror.Write(w, r, apierror.LinkNotFound, nil)
+		return
+	}
+	if result.Valid {
+		expr.Result = &result.Float64
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(expr)
+}
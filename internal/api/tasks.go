@@ -0,0 +1,504 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/calculator"
+	"calculator/internal/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Причины статуса failed
+const (
+	failureDivisionByZero = "division by zero"
+	failureNotFinite      = "result is not a finite number"
+)
+
+// Ошибки приема результата задачи
+var (
+	errTaskNotFound    = errors.New("задача не найдена")
+	errTaskNotLeased   = errors.New("задача выдана другому агенту")
+	errTaskAlreadyDone = errors.New("результат задачи уже получен")
+)
+
+// expressionColumns — колонки выражения для scanExpression, таблица с псевдонимом e
+const expressionColumns = `e.id, e.expression, e.status, e.result, e.user_id,
+	e.created_at, e.started_at, e.completed_at, e.task_count, e.tasks_done, e.compute_ms, e.error,
+	e.variables, e.batch_id, e.client_ref, e.title, e.notes, e.tags`
+
+// rowScanner — общее для *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanExpression читает выражение, выбранное через expressionColumns
+func scanExpression(row rowScanner) (models.Expression, error) {
+	var expr models.Expression
+	var result sql.NullFloat64
+	var createdAt, startedAt, completedAt sql.NullTime
+	var exprErr, variables, batchID, ref, title, notes, tags sql.NullString
+	err := row.Scan(&expr.ID, &expr.Expression, &expr.Status, &result, &expr.UserID,
+		&createdAt, &startedAt, &completedAt, &expr.TaskCount, &expr.TasksDone, &expr.ComputeMS, &exprErr,
+		&variables, &batchID, &ref, &title, &notes, &tags)
+	if err != nil {
+		return expr, err
+	}
+	if variables.Valid {
+		if err := json.Unmarshal([]byte(variables.String), &expr.Variables); err != nil {
+			return expr, err
+		}
+	}
+	if tags.Valid {
+		if err := json.Unmarshal([]byte(tags.String), &expr.Tags); err != nil {
+			return expr, err
+		}
+	}
+	expr.BatchID = batchID.String
+	expr.Ref = ref.String
+	expr.Title = title.String
+	expr.Notes = notes.String
+	if result.Valid {
+		expr.Result = &result.Float64
+	}
+	expr.CreatedAt = nullTimePtr(createdAt)
+	expr.StartedAt = nullTimePtr(startedAt)
+	expr.CompletedAt = nullTimePtr(completedAt)
+	expr.Error = exprErr.String
+	return expr, nil
+}
+
+// compiledExpression — проверенное выражение, готовое к сохранению
+type compiledExpression struct {
+	req     models.CalculationRequest
+	tree    *calculator.Node // дерево до подстановки переменных
+	steps   []calculator.Step
+	value   float64 // значение выражения без операций, например "42" или "x"
+	failure string  // ошибка выражения, уже вычисленного на сервере
+}
+
+// compileExpression разбирает выражение и подставляет переменные.
+// Ошибка оборачивает errInvalidExpression и *calculator.SyntaxError
+// или *metadataError. Метки в req приводятся к нормальному виду.
+func compileExpression(req models.CalculationRequest) (compiledExpression, error) {
+	tags, err := validateMetadata(req.Title, req.Notes, req.Tags)
+	if err != nil {
+		return compiledExpression{}, fmt.Errorf("%w: %w", errInvalidExpression, err)
+	}
+	req.Tags = tags
+	for name := range req.Variables {
+		if !calculator.ValidVariableName(name) {
+			err := &calculator.SyntaxError{Code: calculator.ErrInvalidVariable, Message: fmt.Sprintf("недопустимое имя переменной %q", name)}
+			return compiledExpression{}, fmt.Errorf("%w: %w", errInvalidExpression, err)
+		}
+	}
+	tree, steps, value, err := calculator.Compile(req.Expression, req.Variables)
+	if err != nil {
+		return compiledExpression{}, fmt.Errorf("%w: %w", errInvalidExpression, err)
+	}
+	return compiledExpression{req: req, tree: tree, steps: steps, value: value}, nil
+}
+
+// createdExpression — выражение, сохраненное в транзакции; после commit
+// о нем рассылается событие, а готовые задачи уходят в очередь
+type createdExpression struct {
+	expr  models.Expression
+	ready []models.Task
+}
+
+// createExpressions сохраняет выражения и их задачи в одной транзакции
+// и ставит в очередь задачи, аргументы которых уже известны
+func (h *Handler) createExpressions(userID, batchID string, items []compiledExpression) ([]string, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	if batchID != "" {
+		if _, err := tx.Exec("INSERT INTO batches (id, user_id, item_count, created_at) VALUES (?, ?, ?, ?)",
+			batchID, userID, len(items), now); err != nil {
+			return nil, err
+		}
+	}
+	created := make([]createdExpression, 0, len(items))
+	for _, item := range items {
+		c, err := h.insertExpression(tx, userID, batchID, item, now)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, c)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(created))
+	for i, c := range created {
+		ids[i] = c.expr.ID
+		h.events.publish(EventExpressionCreated, c.expr)
+		h.taskQueue.push(c.ready...)
+		if terminal(c.expr.Status) {
+			h.expressionChanged(c.expr.ID, true)
+		}
+	}
+	return ids, nil
+}
+
+// insertExpression сохраняет одно выражение. Задача, аргумент которой —
+// результат другой задачи, ждет ее: arg1_task/arg2_task ссылаются на
+// задачу-источник, а аргумент заполняется, когда приходит ее результат.
+// Выражение без операций сразу завершается своим значением или ошибкой.
+func (h *Handler) insertExpression(tx *sql.Tx, userID, batchID string, item compiledExpression, now time.Time) (createdExpression, error) {
+	expr := models.Expression{
+		ID: uuid.New().String(), Expression: item.req.Expression, Status: models.StatusPending, UserID: userID,
+		CreatedAt: &now, TaskCount: len(item.steps), Variables: item.req.Variables, BatchID: batchID, Ref: item.req.Ref,
+		Title: item.req.Title, Notes: item.req.Notes, Tags: item.req.Tags,
+	}
+	var result, completedAt, failure interface{}
+	if len(item.steps) == 0 {
+		expr.Status = models.StatusCompleted
+		expr.Result = &item.value
+		expr.StartedAt, expr.CompletedAt = &now, &now
+		result, completedAt = item.value, now
+		if item.failure != "" {
+			expr.Status, expr.Result, expr.Error = models.StatusFailed, nil, item.failure
+			result, failure = nil, item.failure
+		}
+	}
+	var variables interface{}
+	if len(item.req.Variables) > 0 {
+		b, err := json.Marshal(item.req.Variables)
+		if err != nil {
+			return createdExpression{}, err
+		}
+		variables = string(b)
+	}
+	tags, err := tagsColumn(item.req.Tags)
+	if err != nil {
+		return createdExpression{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO expressions (id, expression, status, result, error, user_id, created_at, started_at, completed_at,
+		task_count, variables, batch_id, client_ref, title, notes, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		expr.ID, expr.Expression, string(expr.Status), result, failure, userID, now, completedAt, completedAt,
+		len(item.steps), variables, nullString(batchID), nullString(item.req.Ref),
+		nullString(item.req.Title), nullString(item.req.Notes), tags)
+	if err != nil {
+		return createdExpression{}, err
+	}
+	c := createdExpression{expr: expr}
+	taskIDs := make([]int64, len(item.steps))
+	for i, step := range item.steps {
+		task := models.Task{
+			Arg1:          step.Arg1.Value,
+			Arg2:          step.Arg2.Value,
+			Operation:     step.Operator,
+			OperationTime: h.operationTime(step.Operator),
+		}
+		var arg1Task, arg2Task interface{}
+		if step.Arg1.Step >= 0 {
+			arg1Task = taskIDs[step.Arg1.Step]
+		}
+		if step.Arg2.Step >= 0 {
+			arg2Task = taskIDs[step.Arg2.Step]
+		}
+		res, err := tx.Exec(`INSERT INTO tasks (expression_id, operation, arg1, arg2, arg1_task, arg2_task, operation_time, enqueued_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, expr.ID, task.Operation, task.Arg1, task.Arg2, arg1Task, arg2Task, task.OperationTime, now)
+		if err != nil {
+			return createdExpression{}, err
+		}
+		if taskIDs[i], err = res.LastInsertId(); err != nil {
+			return createdExpression{}, err
+		}
+		task.ID = strconv.FormatInt(taskIDs[i], 10)
+		if arg1Task == nil && arg2Task == nil {
+			c.ready = append(c.ready, task)
+		}
+	}
+	return c, nil
+}
+
+// nullString сохраняет пустую строку как NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// resolveDependents передает результат задачи зависимым задачам и
+// возвращает те из них, у которых теперь известны оба аргумента
+func resolveDependents(tx *sql.Tx, taskID int64, result float64, now time.Time) ([]models.Task, error) {
+	if _, err := tx.Exec("UPDATE tasks SET arg1 = ? WHERE arg1_task = ?", result, taskID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE tasks SET arg2 = ? WHERE arg2_task = ?", result, taskID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT t.id, t.operation, t.arg1, t.arg2, t.operation_time FROM tasks t
+		WHERE (t.arg1_task = ?1 OR t.arg2_task = ?1) AND NOT EXISTS (
+			SELECT 1 FROM tasks d WHERE d.id IN (t.arg1_task, t.arg2_task) AND d.completed_at IS NULL)
+		ORDER BY t.id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ready []models.Task
+	for rows.Next() {
+		var task models.Task
+		var id int64
+		if err := rows.Scan(&id, &task.Operation, &task.Arg1, &task.Arg2, &task.OperationTime); err != nil {
+			return nil, err
+		}
+		task.ID = strconv.FormatInt(id, 10)
+		ready = append(ready, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, task := range ready {
+		if _, err := tx.Exec("UPDATE tasks SET enqueued_at = ? WHERE id = ?", now, task.ID); err != nil {
+			return nil, err
+		}
+	}
+	return ready, nil
+}
+
+// leaseTask отмечает, что задачу получил агент; первое выданное задание
+// переводит выражение в processing
+func (h *Handler) leaseTask(task *models.Task, agentID string) {
+	h.agentSeen(agentID, true)
+	task.AgentID = agentID
+	now := time.Now().UTC()
+	if _, err := h.db.Exec("UPDATE tasks SET leased_at = ?, agent_id = ? WHERE id = ?", now, agentID, task.ID); err != nil {
+		log.Printf("Не удалось отметить выдачу задачи %s: %v", task.ID, err)
+		return
+	}
+	_, err := h.db.Exec(`UPDATE expressions SET status = ?, started_at = ?
+		WHERE id = (SELECT expression_id FROM tasks WHERE id = ?) AND started_at IS NULL`,
+		string(models.StatusProcessing), now, task.ID)
+	if err != nil {
+		log.Printf("Не удалось отметить начало вычисления задачи %s: %v", task.ID, err)
+	}
+	log.Printf("Задача %s выдана агенту %s", task.ID, agentID)
+}
+
+// completeTask принимает результат задачи от агента, который ее получил,
+// и ставит в очередь зависимые задачи, которым хватает аргументов.
+// Когда готовы все задачи выражения, выражение завершается; бесконечный
+// или неопределенный результат операции завершает его с ошибкой.
+func (h *Handler) completeTask(agentID string, taskID int64, result float64) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exprID, operation string
+	var arg2 float64
+	var leasedBy sql.NullString
+	var leasedAt, completedAt sql.NullTime
+	err = tx.QueryRow("SELECT expression_id, operation, arg2, agent_id, leased_at, completed_at FROM tasks WHERE id = ?", taskID).
+		Scan(&exprID, &operation, &arg2, &leasedBy, &leasedAt, &completedAt)
+	if err == sql.ErrNoRows {
+		return errTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !leasedBy.Valid || leasedBy.String != agentID {
+		return errTaskNotLeased
+	}
+	if completedAt.Valid {
+		return errTaskAlreadyDone
+	}
+
+	now := time.Now().UTC()
+	var stored interface{} = result
+	failure := ""
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		stored = nil
+		failure = failureNotFinite
+		if operation == "/" && arg2 == 0 {
+			failure = failureDivisionByZero
+		}
+	}
+	if _, err := tx.Exec("UPDATE tasks SET completed_at = ?, result = ? WHERE id = ?", now, stored, taskID); err != nil {
+		return err
+	}
+	var status models.CalculationStatus
+	if err := tx.QueryRow("SELECT status FROM expressions WHERE id = ?", exprID).Scan(&status); err != nil {
+		return err
+	}
+	// Выражение уже завершилось с ошибкой — оставшиеся задачи ничего не меняют
+	changed := !terminal(status)
+	finished := false
+	var ready []models.Task
+	if changed {
+		computeMS := now.Sub(leasedAt.Time).Milliseconds()
+		if _, err := tx.Exec("UPDATE expressions SET tasks_done = tasks_done + 1, compute_ms = compute_ms + ? WHERE id = ?",
+			computeMS, exprID); err != nil {
+			return err
+		}
+		var done, total int
+		if err := tx.QueryRow("SELECT tasks_done, task_count FROM expressions WHERE id = ?", exprID).Scan(&done, &total); err != nil {
+			return err
+		}
+		if failure == "" {
+			if ready, err = resolveDependents(tx, taskID, result, now); err != nil {
+				return err
+			}
+		}
+		switch {
+		case failure != "":
+			finished = true
+			_, err = tx.Exec("UPDATE expressions SET status = ?, completed_at = ?, error = ? WHERE id = ?",
+				string(models.StatusFailed), now, failure, exprID)
+		case done >= total:
+			finished = true
+			// Результат выражения — результат последней операции
+			_, err = tx.Exec(`UPDATE expressions SET status = ?, completed_at = ?,
+				result = (SELECT result FROM tasks WHERE expression_id = ? ORDER BY id DESC LIMIT 1) WHERE id = ?`,
+				string(models.StatusCompleted), now, exprID, exprID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	h.agentResult(agentID)
+	h.taskQueue.push(ready...)
+	log.Printf("Получен результат задачи %d выражения %s от агента %s: %f", taskID, exprID, agentID, result)
+	if changed {
+		h.expressionChanged(exprID, finished)
+	}
+	return nil
+}
+
+// expressionChanged рассылает событие об изменении выражения; если
+// выражение завершилось, будит long-poll ожидания и ставит вебхуки в очередь
+func (h *Handler) expressionChanged(id string, finished bool) {
+	expr, err := scanExpression(h.db.QueryRow("SELECT "+expressionColumns+" FROM expressions e WHERE e.id = ?", id))
+	if err != nil {
+		log.Printf("Не удалось прочитать выражение %s для событий: %v", id, err)
+		return
+	}
+	h.events.publish(EventExpressionProgress, expr)
+	if finished {
+		event := EventExpressionCompleted
+		if expr.Status == models.StatusFailed {
+			event = EventExpressionFailed
+		}
+		h.events.publish(event, expr)
+		h.waiters.notify(id)
+		h.enqueueWebhooks(event, expr)
+	}
+}
+
+// TaskTimeline — путь одной задачи через очередь
+type TaskTimeline struct {
+	ID            int64      `json:"id"`
+	Operation     string     `json:"operation"`
+	Arg1          float64    `json:"arg1"`
+	Arg2          float64    `json:"arg2"`
+	Arg1Task      *int64     `json:"arg1_task,omitempty"` // задача, результат которой стал arg1
+	Arg2Task      *int64     `json:"arg2_task,omitempty"`
+	OperationTime int64      `json:"operation_time"`
+	EnqueuedAt    time.Time  `json:"enqueued_at"`
+	LeasedAt      *time.Time `json:"leased_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	AgentID       string     `json:"agent_id,omitempty"`
+	Result        *float64   `json:"result,omitempty"`
+}
+
+// readableExpression — выражение, которое видно пользователю: свое или
+// выданное ему на чтение, тогда без полей владельца (см. visibleTo). Нет
+// такого — sql.ErrNoRows.
+func (h *Handler) readableExpression(id, userID string) (models.Expression, error) {
+	expr, err := scanExpression(h.db.QueryRow("SELECT "+expressionColumns+" FROM expressions e WHERE e.id = ? AND "+canReadExpression,
+		id, userID, userID))
+	if err != nil {
+		return expr, err
+	}
+	return visibleTo(expr, userID), nil
+}
+
+// expressionTasks — задачи выражения в порядке создания
+func (h *Handler) expressionTasks(expressionID string) ([]TaskTimeline, error) {
+	rows, err := h.db.Query(`SELECT id, operation, arg1, arg2, arg1_task, arg2_task, operation_time, enqueued_at,
+		leased_at, completed_at, agent_id, result FROM tasks WHERE expression_id = ? ORDER BY id`, expressionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := []TaskTimeline{}
+	for rows.Next() {
+		var t TaskTimeline
+		var leasedAt, completedAt sql.NullTime
+		var agentID sql.NullString
+		var result sql.NullFloat64
+		var arg1Task, arg2Task sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.Operation, &t.Arg1, &t.Arg2, &arg1Task, &arg2Task, &t.OperationTime, &t.EnqueuedAt,
+			&leasedAt, &completedAt, &agentID, &result); err != nil {
+			return nil, err
+		}
+		t.LeasedAt = nullTimePtr(leasedAt)
+		t.CompletedAt = nullTimePtr(completedAt)
+		t.AgentID = agentID.String
+		if arg1Task.Valid {
+			t.Arg1Task = &arg1Task.Int64
+		}
+		if arg2Task.Valid {
+			t.Arg2Task = &arg2Task.Int64
+		}
+		if result.Valid {
+			t.Result = &result.Float64
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// TimelineHandler — время постановки в очередь, выдачи и результата
+// каждой задачи выражения и агент, который ее выполнил
+func (h *Handler) TimelineHandler(w http.ResponseWriter, r *http.Request) {
+	expr, err := h.readableExpression(mux.Vars(r)["id"], GetUserID(r))
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.ExpressionNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	tasks, err := h.expressionTasks(expr.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expression": expr,
+		"tasks":      tasks,
+	})
+}
+
+// parseTaskID переводит строковый id задачи из HTTP API агентов в число
+func parseTaskID(id string) (int64, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errTaskNotFound, id)
+	}
+	return n, nil
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"calculator/internal/apierror"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Время жизни токенов, оркестратор может переопределить их при старте
+var (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// errRefreshReuse — refresh токен уже был обменян: вероятно, его украли
+var errRefreshReuse = errors.New("refresh token reuse detected")
+
+// errRefreshInvalid — токен не найден, отозван или истек
+var errRefreshInvalid = errors.New("invalid refresh token")
+
+// tokenPair — ответ на логин и обновление токенов
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// signAccessToken выпускает короткоживущий access токен с уникальным jti.
+// iat не раньше validAfter пользователя, иначе токен, выданный сразу
+// после смены пароля, попал бы под отзыв.
+func signAccessToken(userID, login, role string, validAfter time.Time) (string, error) {
+	now := time.Now()
+	if now.Before(validAfter) {
+		now = validAfter
+	}
+	claims := UserClaims{
+		UserID: userID,
+		Login:  login,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+	return jwtKeys.Sign(claims)
+}
+
+// newRefreshToken генерирует случайный refresh токен и его хеш для БД
+func newRefreshToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// execer — общее для *sql.DB и *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// storeRefreshToken сохраняет новый refresh токен в семействе familyID
+func storeRefreshToken(db execer, userID, familyID string) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, uuid.New().String(), userID, familyID, hash, now.Add(RefreshTokenTTL), now)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// issueTokenPair выдает access токен и refresh токен нового семейства
+func issueTokenPair(db *sql.DB, userID, login, role string) (*tokenPair, error) {
+	st, err := loadAuthState(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	access, err := signAccessToken(userID, login, role, st.validAfter)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := storeRefreshToken(db, userID, uuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		Token:        access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// rotateRefreshToken обменивает refresh токен на новый в том же семействе.
+// Повторное предъявление уже обменянного токена отзывает все семейство.
+func rotateRefreshToken(db *sql.DB, token string) (userID, refresh string, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var id, familyID string
+	var expiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`SELECT id, user_id, family_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = ?`, hashToken(token)).
+		Scan(&id, &userID, &familyID, &expiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return "", "", errRefreshInvalid
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().UTC()
+	if usedAt.Valid {
+		if err := revokeFamily(tx, familyID); err != nil {
+			return "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", err
+		}
+		log.Printf("Повторное использование refresh токена, семейство %s отозвано", familyID)
+		return "", "", errRefreshReuse
+	}
+	if revokedAt.Valid || now.After(expiresAt) {
+		return "", "", errRefreshInvalid
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ?", now, id); err != nil {
+		return "", "", err
+	}
+	refresh, err = storeRefreshToken(tx, userID, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return userID, refresh, nil
+}
+
+// revokeFamily отзывает все refresh токены семейства
+func revokeFamily(db execer, familyID string) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), familyID)
+	return err
+}
+
+// revokeAccessToken добавляет jti в список отозванных до истечения токена
+func revokeAccessToken(db execer, jti string, expiresAt time.Time) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, expiresAt.UTC())
+	return err
+}
+
+// isTokenRevoked проверяет jti по списку отозванных токенов
+func isTokenRevoked(db *sql.DB, jti string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&n)
+	return n > 0, err
+}
+
+// RefreshHandler — обмен refresh токена на новую пару токенов
+func RefreshHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			apierror.Write(w, r, apierror.BadRequest, nil)
+			return
+		}
+		userID, refresh, err := rotateRefreshToken(db, req.RefreshToken)
+		if errors.Is(err, errRefreshInvalid) || errors.Is(err, errRefreshReuse) {
+			apierror.Write(w, r, apierror.InvalidRefreshToken, nil)
+			return
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		var login, role string
+		var disabled bool
+		var validAfter sql.NullTime
+		err = db.QueryRow("SELECT login, role, disabled, tokens_valid_after FROM users WHERE id = ?", userID).
+			Scan(&login, &role, &disabled, &validAfter)
+		if err != nil || disabled {
+			apierror.Write(w, r, apierror.InvalidRefreshToken, nil)
+			return
+		}
+		access, err := signAccessToken(userID, login, role, validAfter.Time)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokenPair{
+			Token:        access,
+			RefreshToken: refresh,
+			TokenType:    "Bearer",
+			ExpiresIn:    int64(AccessTokenTTL / time.Second),
+		})
+	}
+}
+
+// LogoutHandler — отзыв текущего access токена и, если передан,
+// всего семейства refresh токена. Требует JWTMiddleware.
+func LogoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := GetClaims(r)
+		if claims == nil {
+			apierror.Write(w, r, apierror.Unauthorized, nil)
+			return
+		}
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		// Тело необязательно: без него отзывается только access токен
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if err := revokeAccessToken(db, claims.ID, claims.ExpiresAt.Time); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if req.RefreshToken != "" {
+			var familyID string
+			err := db.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_hash = ? AND user_id = ?",
+				hashToken(req.RefreshToken), claims.UserID).Scan(&familyID)
+			if err == nil {
+				err = revokeFamily(db, familyID)
+			}
+			if err != nil && err != sql.ErrNoRows {
+				apierror.Write(w, r, apierror.InternalError, nil)
+				return
+			}
+		}
+		// Заодно чистим отозванные токены, которые уже истекли сами
+		db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().UTC())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// API v2 работает с тем же хранилищем, что и v1: выражения читаются
+// через readableExpression, listExpressions и expressionTasks, создаются
+// через submitExpression. Отличается только представление: ресурсы
+// отдаются без оберток, все поля присутствуют всегда (нет значения —
+// null), у ответов есть ETag, у созданных ресурсов — Location.
+
+// V2Prefix — префикс маршрутов API v2
+const V2Prefix = "/api/v2"
+
+// Calculation — выражение в представлении v2
+type Calculation struct {
+	ID           string                   `json:"id"`
+	Expression   string                   `json:"expression"`
+	Variables    map[string]float64       `json:"variables"`
+	Status       models.CalculationStatus `json:"status"`
+	StatusDetail StatusDetail             `json:"status_detail"`
+	Result       *float64                 `json:"result"`
+	Ref          *string                  `json:"ref"`
+	Title        *string                  `json:"title"`
+	Notes        *string                  `json:"notes"`
+	Tags         []string                 `json:"tags"`
+	BatchID      *string                  `json:"batch_id"`
+	OwnerID      *string                  `json:"owner_id"` // null для читателя по выданному праву
+	CreatedAt    *time.Time               `json:"created_at"`
+	StartedAt    *time.Time               `json:"started_at"`
+	CompletedAt  *time.Time               `json:"completed_at"`
+	Links        CalculationLinks         `json:"links"`
+}
+
+// StatusDetail — ход вычисления и причина ошибки
+type StatusDetail struct {
+	TasksTotal int     `json:"tasks_total"`
+	TasksDone  int     `json:"tasks_done"`
+	Progress   float64 `json:"progress"`   // доля выполненных задач, 0..1
+	ComputeMS  int64   `json:"compute_ms"` // суммарное время агентов
+	Reason     *string `json:"reason"`     // причина статуса failed
+}
+
+// CalculationLinks — адреса связанных ресурсов
+type CalculationLinks struct {
+	Self  string `json:"self"`
+	Tasks string `json:"tasks"`
+}
+
+// Состояния задачи в v2
+const (
+	TaskWaiting = "waiting" // ждет результата другой задачи
+	TaskQueued  = "queued"  // в очереди на выдачу
+	TaskLeased  = "leased"  // выдана агенту
+	TaskDone    = "done"    // результат получен
+)
+
+// CalculationTask — задача выражения в представлении v2
+type CalculationTask struct {
+	ID              int64      `json:"id"`
+	Operation       string     `json:"operation"`
+	State           string     `json:"state"`
+	Arg1            *float64   `json:"arg1"` // null, пока не готова задача arg1_task
+	Arg2            *float64   `json:"arg2"`
+	Arg1Task        *int64     `json:"arg1_task"`
+	Arg2Task        *int64     `json:"arg2_task"`
+	OperationTimeMS int64      `json:"operation_time_ms"`
+	EnqueuedAt      *time.Time `json:"enqueued_at"` // null, пока задача ждет
+	LeasedAt        *time.Time `json:"leased_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	AgentID         *string    `json:"agent_id"`
+	Result          *float64   `json:"result"`
+}
+
+// collection — список ресурсов v2; next_cursor null на последней странице
+type collection struct {
+	Items      interface{} `json:"items"`
+	NextCursor *string     `json:"next_cursor"`
+	Total      int         `json:"total"`
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// newCalculation переводит выражение из хранилища в представление v2
+func newCalculation(expr models.Expression) Calculation {
+	c := Calculation{
+		ID:          expr.ID,
+		Expression:  expr.Expression,
+		Variables:   expr.Variables,
+		Status:      expr.Status,
+		Result:      expr.Result,
+		Ref:         optionalString(expr.Ref),
+		Title:       optionalString(expr.Title),
+		Notes:       optionalString(expr.Notes),
+		Tags:        expr.Tags,
+		BatchID:     optionalString(expr.BatchID),
+		OwnerID:     optionalString(expr.UserID),
+		CreatedAt:   expr.CreatedAt,
+		StartedAt:   expr.StartedAt,
+		CompletedAt: expr.CompletedAt,
+		StatusDetail: StatusDetail{
+			TasksTotal: expr.TaskCount,
+			TasksDone:  expr.TasksDone,
+			Progress:   1,
+			ComputeMS:  expr.ComputeMS,
+			Reason:     optionalString(expr.Error),
+		},
+		Links: CalculationLinks{
+			Self:  V2Prefix + "/calculations/" + expr.ID,
+			Tasks: V2Prefix + "/calculations/" + expr.ID + "/tasks",
+		},
+	}
+	if c.Variables == nil {
+		c.Variables = map[string]float64{}
+	}
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	if expr.TaskCount > 0 {
+		c.StatusDetail.Progress = float64(expr.TasksDone) / float64(expr.TaskCount)
+	}
+	return c
+}
+
+// newCalculationTasks переводит задачи в представление v2. Аргумент,
+// который ждет результата другой задачи, — null.
+func newCalculationTasks(tasks []TaskTimeline) []CalculationTask {
+	done := make(map[int64]bool, len(tasks))
+	for _, t := range tasks {
+		done[t.ID] = t.CompletedAt != nil
+	}
+	out := make([]CalculationTask, 0, len(tasks))
+	for _, t := range tasks {
+		ct := CalculationTask{
+			ID:              t.ID,
+			Operation:       t.Operation,
+			Arg1Task:        t.Arg1Task,
+			Arg2Task:        t.Arg2Task,
+			OperationTimeMS: t.OperationTime,
+			LeasedAt:        t.LeasedAt,
+			CompletedAt:     t.CompletedAt,
+			AgentID:         optionalString(t.AgentID),
+			Result:          t.Result,
+		}
+		arg1, arg2 := t.Arg1, t.Arg2
+		ready := true
+		if t.Arg1Task == nil || done[*t.Arg1Task] {
+			ct.Arg1 = &arg1
+		} else {
+			ready = false
+		}
+		if t.Arg2Task == nil || done[*t.Arg2Task] {
+			ct.Arg2 = &arg2
+		} else {
+			ready = false
+		}
+		switch {
+		case t.CompletedAt != nil:
+			ct.State = TaskDone
+		case t.LeasedAt != nil:
+			ct.State = TaskLeased
+		case ready:
+			ct.State = TaskQueued
+		default:
+			ct.State = TaskWaiting
+		}
+		if ready {
+			enqueuedAt := t.EnqueuedAt
+			ct.EnqueuedAt = &enqueuedAt
+		}
+		out = append(out, ct)
+	}
+	return out
+}
+
+// etag — сильный ETag тела ответа
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches — есть ли tag в списке If-None-Match / If-Match. If-None-Match
+// сравнивает слабо: W/"x" совпадает с "x"; If-Match — строго, слабые
+// ETag в нем не совпадают ни с чем (RFC 9110, 8.8.3.2).
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResource отвечает JSON с ETag. На GET с совпавшим If-None-Match
+// отвечает 304 без тела.
+func writeResource(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	body = append(body, '\n')
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && status == http.StatusOK {
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// CreateCalculationHandler — POST /api/v2/calculations
+func (h *Handler) CreateCalculationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	var req models.CalculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	id, err := h.submitExpression(userID, req)
+	if errors.Is(err, errInvalidExpression) {
+		writeSyntaxError(w, r, err)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	expr, err := h.readableExpression(id, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	c := newCalculation(expr)
+	w.Header().Set("Location", c.Links.Self)
+	writeResource(w, r, http.StatusCreated, c)
+}
+
+// ListCalculationsHandler — GET /api/v2/calculations, параметры как у
+// GET /api/v1/expressions
+func (h *Handler) ListCalculationsHandler(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"reason": err.Error()})
+		return
+	}
+	expressions, next, total, err := h.listExpressions(GetUserID(r), lq)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	items := make([]Calculation, 0, len(expressions))
+	for _, expr := range expressions {
+		items = append(items, newCalculation(expr))
+	}
+	writeResource(w, r, http.StatusOK, collection{Items: items, NextCursor: optionalString(next), Total: total})
+}
+
+// loadCalculation читает выражение из {id} или отвечает ошибкой
+func (h *Handler) loadCalculation(w http.ResponseWriter, r *http.Request) (models.Expression, bool) {
+	expr, err := h.readableExpression(mux.Vars(r)["id"], GetUserID(r))
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.ExpressionNotFound, nil)
+		return expr, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return expr, false
+	}
+	return expr, true
+}
+
+// GetCalculationHandler — GET /api/v2/calculations/{id}
+func (h *Handler) GetCalculationHandler(w http.ResponseWriter, r *http.Request) {
+	expr, ok := h.loadCalculation(w, r)
+	if !ok {
+		return
+	}
+	writeResource(w, r, http.StatusOK, newCalculation(expr))
+}
+
+// PatchCalculationHandler — PATCH /api/v2/calculations/{id}: изменение
+// метаданных владельцем. Тело — JSON merge patch; null очищает поле.
+// С If-Match изменение применяется, только если ресурс не менялся.
+func (h *Handler) PatchCalculationHandler(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	var precondition func(models.Expression) bool
+	if im := r.Header.Get("If-Match"); im != "" {
+		precondition = func(expr models.Expression) bool {
+			current, _ := json.Marshal(newCalculation(expr))
+			return etagMatches(im, etag(append(current, '\n')), false)
+		}
+	}
+	expr, err := h.patchMetadata(mux.Vars(r)["id"], GetUserID(r), patch, precondition)
+	if err != nil {
+		writePatchError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, newCalculation(expr))
+}
+
+// CalculationTasksHandler — GET /api/v2/calculations/{id}/tasks
+func (h *Handler) CalculationTasksHandler(w http.ResponseWriter, r *http.Request) {
+	expr, ok := h.loadCalculation(w, r)
+	if !ok {
+		return
+	}
+	tasks, err := h.expressionTasks(expr.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	items := newCalculationTasks(tasks)
+	writeResource(w, r, http.StatusOK, collection{Items: items, Total: len(items)})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"calculator/internal/models"
+)
+
+// MaxWait — предел параметра ?wait= у GET /api/v1/expressions/{id}
+var MaxWait = 60 * time.Second
+
+// waiters — ожидающие завершения выражений запросы. Канал закрывается,
+// когда выражение переходит в конечное состояние.
+type waiters struct {
+	mu sync.Mutex
+	m  map[string]map[chan struct{}]struct{}
+}
+
+// subscribe регистрирует ожидание выражения. cancel нужно вызвать
+// в любом случае, он снимает ожидание сразу, не дожидаясь уведомления.
+func (ws *waiters) subscribe(id string) (done <-chan struct{}, cancel func()) {
+	ch := make(chan struct{})
+	ws.mu.Lock()
+	if ws.m == nil {
+		ws.m = make(map[string]map[chan struct{}]struct{})
+	}
+	if ws.m[id] == nil {
+		ws.m[id] = make(map[chan struct{}]struct{})
+	}
+	ws.m[id][ch] = struct{}{}
+	ws.mu.Unlock()
+	return ch, func() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		if _, ok := ws.m[id][ch]; ok {
+			delete(ws.m[id], ch)
+			if len(ws.m[id]) == 0 {
+				delete(ws.m, id)
+			}
+		}
+	}
+}
+
+// notify будит всех, кто ждет выражение
+func (ws *waiters) notify(id string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for ch := range ws.m[id] {
+		close(ch)
+	}
+	delete(ws.m, id)
+}
+
+// terminal — выражение больше не изменится
+func terminal(s models.CalculationStatus) bool {
+	return s == models.StatusCompleted || s == models.StatusFailed
+}
+
+// parseWait разбирает ?wait=: длительность Go ("30s") или число секунд
+func parseWait(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("wait")
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		d, err = time.ParseDuration(s + "s")
+	}
+	if err != nil || d < 0 || d > MaxWait {
+		return 0, errors.New("wait must be a duration between 0s and " + MaxWait.String())
+	}
+	return d, nil
+}
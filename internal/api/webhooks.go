@@ -0,0 +1,508 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Заголовки запроса вебхука
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature" // "sha256=<hex HMAC-SHA256(secret, timestamp + "." + body)>"
+	WebhookTimestampHeader = "X-Webhook-Timestamp" // unix-время отправки, входит в подпись
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Статусы доставки
+const (
+	DeliveryPending   = "pending"   // ждет первой или повторной попытки
+	DeliveryDelivered = "delivered" // получатель ответил 2xx
+	DeliveryDead      = "dead"      // попытки исчерпаны
+)
+
+// Параметры доставки вебхуков
+var (
+	MaxWebhooksPerUser  = 10
+	WebhookMaxAttempts  = 8
+	WebhookBackoff      = 10 * time.Second // пауза после первой неудачи, дальше удваивается
+	WebhookMaxBackoff   = time.Hour
+	WebhookPollInterval = time.Second
+	WebhookWorkers      = 4
+	// WebhookAllowPrivate разрешает доставку на loopback и адреса внутренних
+	// сетей; включается только для разработки и тестов
+	WebhookAllowPrivate = false
+	webhookClient       = &http.Client{
+		Timeout: 10 * time.Second,
+		// Редирект считается неудачей: подписанное тело не должно уходить на другой адрес
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport: &http.Transport{
+			// Без прокси: адрес проверяется при соединении с самим получателем
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: webhookDialControl,
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+)
+
+// errWebhookAddressForbidden — адрес получателя во внутренней сети
+var errWebhookAddressForbidden = errors.New("webhook address is not public")
+
+// webhookAddressAllowed проверяет адрес получателя вебхука: loopback,
+// внутренние, link-local и неуказанные адреса запрещены
+func webhookAddressAllowed(ip net.IP) bool {
+	if WebhookAllowPrivate {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// webhookDialControl проверяет адрес уже после разрешения имени, поэтому
+// имя, которое указывает во внутреннюю сеть, тоже не пропускается
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddressForbidden, host)
+	}
+	return nil
+}
+
+// webhookEvents — события, на которые можно подписать вебхук
+var webhookEvents = []string{EventExpressionCompleted, EventExpressionFailed}
+
+// Webhook — адрес, на который отправляются события выражений пользователя
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery — запись журнала доставки одного события
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	WebhookID     string     `json:"webhook_id"`
+	Event         string     `json:"event"`
+	ExpressionID  string     `json:"expression_id"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatus    int        `json:"last_status,omitempty"` // HTTP код последнего ответа
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// webhookPayload — тело запроса вебхука
+type webhookPayload struct {
+	ID        string            `json:"id"`
+	Event     string            `json:"event"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      models.Expression `json:"data"`
+}
+
+// SignWebhook вычисляет значение заголовка X-Webhook-Signature.
+// Получатель сверяет его с помощью hmac.Equal и отбрасывает старые timestamp.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret генерирует секрет подписи. В отличие от токенов он
+// хранится открытым: без него нельзя подписать запрос.
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func validWebhookEvent(e string) bool {
+	for _, v := range webhookEvents {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookBackoff — пауза перед следующей попыткой после attempts неудачных
+func webhookBackoff(attempts int) time.Duration {
+	d := WebhookBackoff
+	for i := 1; i < attempts && d < WebhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > WebhookMaxBackoff {
+		d = WebhookMaxBackoff
+	}
+	return d
+}
+
+// CreateWebhookHandler — регистрация вебхука. Секрет подписи
+// возвращается только в этом ответе.
+func (h *Handler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"` // пусто — все события
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.BadRequest, nil)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "url"})
+		return
+	}
+	// Адрес, заданный IP, отклоняется сразу; имя проверяется при доставке
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !webhookAddressAllowed(ip) {
+		apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "url"})
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEvents
+	}
+	for _, e := range req.Events {
+		if !validWebhookEvent(e) {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "events", "value": e})
+			return
+		}
+	}
+
+	userID := GetUserID(r)
+	var n int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM webhooks WHERE user_id = ?", userID).Scan(&n); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if n >= MaxWebhooksPerUser {
+		apierror.Write(w, r, apierror.TooManyWebhooks, nil)
+		return
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	hook := Webhook{ID: uuid.New().String(), URL: u.String(), Events: req.Events, CreatedAt: time.Now().UTC()}
+	_, err = h.db.Exec("INSERT INTO webhooks (id, user_id, url, secret, events, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		hook.ID, userID, hook.URL, secret, strings.Join(hook.Events, ","), hook.CreatedAt)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Webhook
+		Secret string `json:"secret"`
+	}{hook, secret})
+}
+
+// ListWebhooksHandler — вебхуки текущего пользователя
+func (h *Handler) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query("SELECT id, url, events, created_at FROM webhooks WHERE user_id = ? ORDER BY created_at", GetUserID(r))
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var hook Webhook
+		var events string
+		if err := rows.Scan(&hook.ID, &hook.URL, &events, &hook.CreatedAt); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		hook.Events = strings.Split(events, ",")
+		hooks = append(hooks, hook)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": hooks})
+}
+
+// DeleteWebhookHandler — удалить вебхук вместе с журналом доставки
+func (h *Handler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireWebhook(w, r)
+	if !ok {
+		return
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveriesHandler — журнал доставки, новые первыми.
+// ?status=dead — недоставленные события.
+func (h *Handler) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireWebhook(w, r)
+	if !ok {
+		return
+	}
+	query := `SELECT id, webhook_id, event, expression_id, status, attempts, next_attempt_at,
+		last_status, last_error, created_at, delivered_at FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []interface{}{id}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if status != DeliveryPending && status != DeliveryDelivered && status != DeliveryDead {
+			apierror.Write(w, r, apierror.InvalidParameter, apierror.Details{"parameter": "status", "value": status})
+			return
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := h.db.Query(query+" ORDER BY created_at DESC LIMIT 100", args...)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttemptAt, deliveredAt sql.NullTime
+		var lastStatus sql.NullInt64
+		var lastError sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.ExpressionID, &d.Status, &d.Attempts, &nextAttemptAt,
+			&lastStatus, &lastError, &d.CreatedAt, &deliveredAt); err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
+		if d.Status == DeliveryPending {
+			d.NextAttemptAt = nullTimePtr(nextAttemptAt)
+		}
+		d.LastStatus = int(lastStatus.Int64)
+		d.LastError = lastError.String
+		d.DeliveredAt = nullTimePtr(deliveredAt)
+		deliveries = append(deliveries, d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries})
+}
+
+// RedeliverWebhookHandler — вернуть недоставленное событие в очередь
+func (h *Handler) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireWebhook(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND webhook_id = ? AND status = ?`,
+		DeliveryPending, time.Now().UTC(), mux.Vars(r)["delivery"], id, DeliveryDead)
+	if err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.DeliveryNotFound, nil)
+		return
+	}
+	h.wakeWebhooks()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireWebhook проверяет, что вебхук из пути принадлежит текущему пользователю
+func (h *Handler) requireWebhook(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["id"]
+	var n int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM webhooks WHERE id = ? AND user_id = ?", id, GetUserID(r)).Scan(&n); err != nil {
+		apierror.Write(w, r, apierror.InternalError, nil)
+		return "", false
+	}
+	if n == 0 {
+		apierror.Write(w, r, apierror.WebhookNotFound, nil)
+		return "", false
+	}
+	return id, true
+}
+
+// enqueueWebhooks ставит событие в журнал доставки всех подписанных вебхуков
+func (h *Handler) enqueueWebhooks(event string, expr models.Expression) {
+	rows, err := h.db.Query("SELECT id, events FROM webhooks WHERE user_id = ?", expr.UserID)
+	if err != nil {
+		log.Printf("Вебхуки выражения %s: %v", expr.ID, err)
+		return
+	}
+	var hooks []string
+	for rows.Next() {
+		var id, events string
+		if rows.Scan(&id, &events) == nil && strings.Contains(","+events+",", ","+event+",") {
+			hooks = append(hooks, id)
+		}
+	}
+	rows.Close()
+	if len(hooks) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, hookID := range hooks {
+		payload := webhookPayload{ID: uuid.New().String(), Event: event, CreatedAt: now, Data: expr}
+		body, _ := json.Marshal(payload)
+		_, err := h.db.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, event, expression_id, payload, status,
+			attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)`,
+			payload.ID, hookID, event, expr.ID, string(body), DeliveryPending, now, now)
+		if err != nil {
+			log.Printf("Вебхук %s: не удалось поставить событие в очередь: %v", hookID, err)
+		}
+	}
+	h.wakeWebhooks()
+}
+
+func (h *Handler) wakeWebhooks() {
+	select {
+	case h.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookDispatcher доставляет события, пока не отменен ctx
+func (h *Handler) RunWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(WebhookPollInterval)
+	defer ticker.Stop()
+	for {
+		h.DeliverWebhooks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.webhookWake:
+		}
+	}
+}
+
+// DeliverWebhooks делает по одной попытке для всех доставок, срок которых
+// наступил, и возвращает их число
+func (h *Handler) DeliverWebhooks(ctx context.Context) int {
+	type due struct {
+		id, event, payload, url, secret string
+		attempts                        int
+	}
+	rows, err := h.db.Query(`SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? ORDER BY d.next_attempt_at LIMIT 100`,
+		DeliveryPending, time.Now().UTC())
+	if err != nil {
+		log.Printf("Вебхуки: %v", err)
+		return 0
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err == nil {
+			batch = append(batch, d)
+		}
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, WebhookWorkers)
+	for _, d := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d due) {
+			defer func() { <-sem; wg.Done() }()
+			status, err := postWebhook(ctx, d.url, d.secret, d.id, d.event, []byte(d.payload))
+			h.recordWebhookAttempt(d.id, d.attempts+1, status, err)
+		}(d)
+	}
+	wg.Wait()
+	return len(batch)
+}
+
+// postWebhook отправляет подписанный запрос; успех — ответ 2xx
+func postWebhook(ctx context.Context, target, secret, deliveryID, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "calculator-webhooks/1")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, ts, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordWebhookAttempt пишет результат попытки в журнал; после
+// WebhookMaxAttempts неудач доставка уходит в dead
+func (h *Handler) recordWebhookAttempt(id string, attempts, status int, sendErr error) {
+	now := time.Now().UTC()
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = h.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status = ?, last_error = NULL,
+			delivered_at = ? WHERE id = ?`, DeliveryDelivered, attempts, status, now, id)
+	case attempts >= WebhookMaxAttempts:
+		log.Printf("Вебхук: доставка %s не удалась после %d попыток: %v", id, attempts, sendErr)
+		_, err = h.db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status = ?, last_error = ? WHERE id = ?",
+			DeliveryDead, attempts, status, sendErr.Error(), id)
+	default:
+		_, err = h.db.Exec(`UPDATE webhook_deliveries SET attempts = ?, last_status = ?, last_error = ?,
+			next_attempt_at = ? WHERE id = ?`, attempts, status, sendErr.Error(), now.Add(webhookBackoff(attempts)), id)
+	}
+	if err != nil {
+		log.Printf("Вебхук: не удалось записать попытку доставки %s: %v", id, err)
+	}
+}
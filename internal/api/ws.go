@@ -0,0 +1,215 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"golang.org/x/net/websocket"
+)
+
+// WSProtocol — подпротокол WebSocket API. Браузер не может передать
+// заголовок Authorization, поэтому JWT можно передать вторым подпротоколом
+// "bearer.<token>": new WebSocket(url, ["calculator.v1", "bearer." + token]).
+const WSProtocol = "calculator.v1"
+
+const wsBearerPrefix = "bearer."
+
+// Ограничения одного WebSocket соединения
+var (
+	WSMaxInFlight = 32
+	WSMaxPayload  = 64 << 10
+)
+
+// wsMessage — сообщение клиента
+type wsMessage struct {
+	Type       string             `json:"type"`
+	ID         string             `json:"id"` // идентификатор корреляции, выбирает клиент
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+}
+
+// wsReply — сообщение сервера: ack, progress, result или error
+type wsReply struct {
+	Type         string             `json:"type"`
+	ID           string             `json:"id,omitempty"`
+	ExpressionID string             `json:"expression_id,omitempty"`
+	Expression   *models.Expression `json:"expression,omitempty"`
+	Error        *apierror.Error    `json:"error,omitempty"`
+}
+
+// WebSocketTokenMiddleware переносит JWT из подпротокола "bearer.<token>"
+// в заголовок Authorization, чтобы его проверил обычный JWTMiddleware
+func WebSocketTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			for _, p := range wsProtocols(r) {
+				if strings.HasPrefix(p, wsBearerPrefix) {
+					r.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(p, wsBearerPrefix))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func wsProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+// WebSocketHandler — /api/v1/ws. Пользователь уже проверен JWTMiddleware
+// при установке соединения.
+func (h *Handler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		apierror.Write(w, r, apierror.Unauthorized, nil)
+		return
+	}
+	srv := websocket.Server{
+		// Origin не проверяем: доступ дает токен, а не cookie.
+		// Клиенту возвращаем только наш подпротокол, токен в ответ не попадает.
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			protocols := config.Protocol
+			config.Protocol = nil
+			for _, p := range protocols {
+				if p == WSProtocol {
+					config.Protocol = []string{WSProtocol}
+				}
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = WSMaxPayload
+			h.serveWS(ws, r, userID)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// serveWS обслуживает одно соединение. Все записи в сокет делает этот
+// цикл: он же принимает выражения и раздает события по ним, поэтому
+// выражение регистрируется раньше, чем приходит любое событие о нем.
+// r — запрос на установку соединения: из него берутся язык ошибок и request_id.
+func (h *Handler) serveWS(ws *websocket.Conn, r *http.Request, userID string) {
+	defer ws.Close()
+	done := make(chan struct{})
+	defer close(done)
+
+	incoming := make(chan wsMessage)
+	go func() {
+		defer close(incoming)
+		for {
+			var msg wsMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+			select {
+			case incoming <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	lastID := h.events.lastID()
+	missed, events, cancel := h.events.subscribe(userID, lastID)
+	defer func() { cancel() }()
+	inFlight := map[string]string{} // id выражения -> id корреляции клиента
+
+	send := func(reply wsReply) bool {
+		if err := websocket.JSON.Send(ws, reply); err != nil {
+			log.Printf("WebSocket %s: ошибка отправки: %v", userID, err)
+			return false
+		}
+		return true
+	}
+	handleEvent := func(e Event) bool {
+		lastID = e.ID
+		corrID, ok := inFlight[e.Data.ID]
+		if !ok {
+			return true
+		}
+		expr := e.Data
+		switch e.Type {
+		case EventExpressionProgress:
+			return send(wsReply{Type: "progress", ID: corrID, ExpressionID: expr.ID, Expression: &expr})
+		case EventExpressionCompleted, EventExpressionFailed:
+			delete(inFlight, expr.ID)
+			return send(wsReply{Type: "result", ID: corrID, ExpressionID: expr.ID, Expression: &expr})
+		}
+		return true
+	}
+
+	for {
+		for _, e := range missed {
+			if !handleEvent(e) {
+				return
+			}
+		}
+		missed = nil
+		select {
+		case msg, ok := <-incoming:
+			if !ok {
+				return
+			}
+			if !send(h.wsCalculate(r, userID, msg, inFlight)) {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				// Отстали от потока событий — переподписываемся с последнего
+				// обработанного события, пропущенное придет из буфера
+				cancel()
+				missed, events, cancel = h.events.subscribe(userID, lastID)
+				continue
+			}
+			if !handleEvent(e) {
+				return
+			}
+		}
+	}
+}
+
+// wsError — сообщение error с ошибкой в едином формате
+func wsError(r *http.Request, id string, code apierror.Code, details apierror.Details) wsReply {
+	e := apierror.New(r, code, details)
+	return wsReply{Type: "error", ID: id, Error: &e}
+}
+
+// wsCalculate принимает выражение тем же путем, что и POST /api/v1/calculate
+func (h *Handler) wsCalculate(r *http.Request, userID string, msg wsMessage, inFlight map[string]string) wsReply {
+	switch {
+	case msg.Type != "calculate":
+		return wsError(r, msg.ID, apierror.InvalidParameter, apierror.Details{"parameter": "type", "reason": "unknown message type"})
+	case msg.ID == "":
+		return wsError(r, "", apierror.InvalidParameter, apierror.Details{"parameter": "id", "reason": "required"})
+	case len(inFlight) >= WSMaxInFlight:
+		return wsError(r, msg.ID, apierror.TooManyInFlight, apierror.Details{"max": WSMaxInFlight})
+	}
+	for _, corrID := range inFlight {
+		if corrID == msg.ID {
+			return wsError(r, msg.ID, apierror.InvalidParameter, apierror.Details{"parameter": "id", "reason": "already in flight"})
+		}
+	}
+	id, err := h.submitExpression(userID, models.CalculationRequest{Expression: msg.Expression, Variables: msg.Variables})
+	if errors.Is(err, errInvalidExpression) {
+		e := syntaxError(r, err)
+		return wsReply{Type: "error", ID: msg.ID, Error: &e}
+	}
+	if err != nil {
+		return wsError(r, msg.ID, apierror.InternalError, nil)
+	}
+	inFlight[id] = msg.ID
+	return wsReply{Type: "ack", ID: msg.ID, ExpressionID: id}
+}
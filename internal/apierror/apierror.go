@@ -0,0 +1,271 @@
+// Package apierror — единый формат ошибок HTTP API:
+//
+//	{"error": {"code": "...", "message": "...", "details": {...}, "request_id": "..."}}
+//
+// Код стабилен, клиенты сравнивают его, а не текст. Текст переводится по
+// Accept-Language (ru, en), по умолчанию — на русский.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Code — стабильный код ошибки
+type Code string
+
+// Details — дополнительные поля ошибки, зависят от кода
+type Details map[string]interface{}
+
+// Общие ошибки запроса и авторизации
+const (
+	BadRequest             Code = "bad_request"
+	InvalidParameter       Code = "invalid_parameter"
+	Unauthorized           Code = "unauthorized"
+	InvalidToken           Code = "invalid_token"
+	TokenRevoked           Code = "token_revoked"
+	InvalidAPIKey          Code = "invalid_api_key"
+	InvalidAgentToken      Code = "invalid_agent_token"
+	InvalidCredentials     Code = "invalid_credentials"
+	InvalidRefreshToken    Code = "invalid_refresh_token"
+	CredentialsRequired    Code = "credentials_required"
+	AccountDisabled        Code = "account_disabled"
+	Forbidden              Code = "forbidden"
+	InsufficientScope      Code = "insufficient_scope"
+	SessionRequired        Code = "session_required"
+	TooManyLoginAttempts   Code = "too_many_login_attempts"
+	PasswordTooShort       Code = "password_too_short"
+	PasswordTooLong        Code = "password_too_long"
+	PasswordTooCommon      Code = "password_too_common"
+	PasswordMatchesLogin   Code = "password_matches_login"
+	UserExists             Code = "user_exists"
+	CannotChangeOwnAccount Code = "cannot_change_own_account"
+	MethodNotAllowed       Code = "method_not_allowed"
+	InternalError          Code = "internal_error"
+)
+
+// Ресурсы, которые не найдены или недоступны
+const (
+	NotFound           Code = "not_found"
+	UserNotFound       Code = "user_not_found"
+	ExpressionNotFound Code = "expression_not_found"
+	BatchNotFound      Code = "batch_not_found"
+	WebhookNotFound    Code = "webhook_not_found"
+	DeliveryNotFound   Code = "delivery_not_found"
+	APIKeyNotFound     Code = "api_key_not_found"
+	LinkNotFound       Code = "link_not_found"
+	GrantNotFound      Code = "grant_not_found"
+	TaskNotFound       Code = "task_not_found"
+	NoTask             Code = "no_task"
+)
+
+// Ошибки изменения ресурсов
+const (
+	TaskNotLeased            Code = "task_not_leased"
+	TaskAlreadyDone          Code = "task_already_done"
+	InvalidTaskResult        Code = "invalid_task_result"
+	TooManyAPIKeys           Code = "too_many_api_keys"
+	TooManyWebhooks          Code = "too_many_webhooks"
+	CannotShareWithSelf      Code = "cannot_share_with_self"
+	IdempotencyKeyMismatch   Code = "idempotency_key_mismatch"
+	IdempotencyKeyInProgress Code = "idempotency_key_in_progress"
+	EvaluationFailed         Code = "evaluation_failed"
+	InvalidExpressions       Code = "invalid_expressions"
+	PreconditionFailed       Code = "precondition_failed"
+	TooManyInFlight          Code = "too_many_in_flight"
+)
+
+// Ошибки разбора выражения; совпадают с кодами calculator.SyntaxError
+const (
+	EmptyExpression       Code = "empty_expression"
+	UnexpectedCharacter   Code = "unexpected_character"
+	UnexpectedToken       Code = "unexpected_token"
+	UnexpectedEnd         Code = "unexpected_end"
+	UnbalancedParenthesis Code = "unbalanced_parenthesis"
+	InvalidNumber         Code = "invalid_number"
+	UnknownVariable       Code = "unknown_variable"
+	InvalidVariable       Code = "invalid_variable"
+	ExpressionTooLong     Code = "expression_too_long"
+)
+
+// entry — HTTP статус и тексты ошибки
+type entry struct {
+	status int
+	ru, en string
+}
+
+var catalogue = map[Code]entry{
+	BadRequest:             {http.StatusBadRequest, "Некорректное тело запроса", "Malformed request body"},
+	InvalidParameter:       {http.StatusBadRequest, "Неверный параметр запроса", "Invalid request parameter"},
+	Unauthorized:           {http.StatusUnauthorized, "Требуется авторизация", "Authentication required"},
+	InvalidToken:           {http.StatusUnauthorized, "Недействительный токен", "Invalid token"},
+	TokenRevoked:           {http.StatusUnauthorized, "Токен отозван", "Token revoked"},
+	InvalidAPIKey:          {http.StatusUnauthorized, "Недействительный API ключ", "Invalid API key"},
+	InvalidAgentToken:      {http.StatusUnauthorized, "Недействительный токен агента", "Invalid agent token"},
+	InvalidCredentials:     {http.StatusUnauthorized, "Неверный логин или пароль", "Invalid login or password"},
+	InvalidRefreshToken:    {http.StatusUnauthorized, "Недействительный refresh токен", "Invalid refresh token"},
+	CredentialsRequired:    {http.StatusBadRequest, "Нужны логин и пароль", "Login and password are required"},
+	AccountDisabled:        {http.StatusForbidden, "Аккаунт отключен", "Account disabled"},
+	Forbidden:              {http.StatusForbidden, "Недостаточно прав", "Forbidden"},
+	InsufficientScope:      {http.StatusForbidden, "У API ключа нет нужной области доступа", "API key lacks the required scope"},
+	SessionRequired:        {http.StatusForbidden, "Недоступно при входе по API ключу", "Not allowed with an API key"},
+	TooManyLoginAttempts:   {http.StatusTooManyRequests, "Слишком много неудачных попыток входа", "Too many failed login attempts"},
+	PasswordTooShort:       {http.StatusBadRequest, "Пароль слишком короткий", "Password is too short"},
+	PasswordTooLong:        {http.StatusBadRequest, "Пароль слишком длинный", "Password is too long"},
+	PasswordTooCommon:      {http.StatusBadRequest, "Пароль слишком распространенный", "Password is too common"},
+	PasswordMatchesLogin:   {http.StatusBadRequest, "Пароль совпадает с логином", "Password must not match the login"},
+	UserExists:             {http.StatusConflict, "Пользователь уже существует", "User already exists"},
+	CannotChangeOwnAccount: {http.StatusBadRequest, "Нельзя изменить собственный аккаунт", "Cannot change your own account"},
+	MethodNotAllowed:       {http.StatusMethodNotAllowed, "Метод не поддерживается", "Method not allowed"},
+	InternalError:          {http.StatusInternalServerError, "Внутренняя ошибка сервера", "Internal server error"},
+
+	NotFound:           {http.StatusNotFound, "Ресурс не найден", "Resource not found"},
+	UserNotFound:       {http.StatusNotFound, "Пользователь не найден", "User not found"},
+	ExpressionNotFound: {http.StatusNotFound, "Выражение не найдено", "Expression not found"},
+	BatchNotFound:      {http.StatusNotFound, "Пакет не найден", "Batch not found"},
+	WebhookNotFound:    {http.StatusNotFound, "Вебхук не найден", "Webhook not found"},
+	DeliveryNotFound:   {http.StatusNotFound, "Доставка не найдена", "Delivery not found"},
+	APIKeyNotFound:     {http.StatusNotFound, "API ключ не найден", "API key not found"},
+	LinkNotFound:       {http.StatusNotFound, "Ссылка не найдена", "Link not found"},
+	GrantNotFound:      {http.StatusNotFound, "Доступ не найден", "Grant not found"},
+	TaskNotFound:       {http.StatusNotFound, "Задача не найдена", "Task not found"},
+	NoTask:             {http.StatusNotFound, "Нет задач", "No tasks available"},
+
+	TaskNotLeased:            {http.StatusForbidden, "Задача выдана другому агенту", "Task is leased to another agent"},
+	TaskAlreadyDone:          {http.StatusConflict, "Результат задачи уже получен", "Task result already received"},
+	InvalidTaskResult:        {http.StatusUnprocessableEntity, "Ошибка декодирования результата задачи", "Malformed task result"},
+	TooManyAPIKeys:           {http.StatusConflict, "Слишком много API ключей", "Too many API keys"},
+	TooManyWebhooks:          {http.StatusConflict, "Слишком много вебхуков", "Too many webhooks"},
+	CannotShareWithSelf:      {http.StatusBadRequest, "Нельзя выдать доступ самому себе", "Cannot share with yourself"},
+	IdempotencyKeyMismatch:   {http.StatusConflict, "Ключ идемпотентности использован с другим телом запроса", "Idempotency key was used with a different request body"},
+	IdempotencyKeyInProgress: {http.StatusConflict, "Запрос с этим ключом идемпотентности еще выполняется", "A request with this idempotency key is still in progress"},
+	EvaluationFailed:         {http.StatusUnprocessableEntity, "Операция дала недопустимый результат", "An operation produced an invalid result"},
+	InvalidExpressions:       {http.StatusUnprocessableEntity, "Ни одно выражение пакета не прошло проверку", "No expression in the batch is valid"},
+	PreconditionFailed:       {http.StatusPreconditionFailed, "Ресурс изменился с момента чтения", "Resource has changed since it was read"},
+	TooManyInFlight:          {http.StatusTooManyRequests, "Слишком много выражений в работе", "Too many expressions in flight"},
+
+	EmptyExpression:       {http.StatusUnprocessableEntity, "Пустое выражение", "Empty expression"},
+	UnexpectedCharacter:   {http.StatusUnprocessableEntity, "Недопустимый символ", "Unexpected character"},
+	UnexpectedToken:       {http.StatusUnprocessableEntity, "Неожиданный элемент выражения", "Unexpected token"},
+	UnexpectedEnd:         {http.StatusUnprocessableEntity, "Выражение оборвано", "Unexpected end of expression"},
+	UnbalancedParenthesis: {http.StatusUnprocessableEntity, "Несбалансированные скобки", "Unbalanced parenthesis"},
+	InvalidNumber:         {http.StatusUnprocessableEntity, "Неверное число", "Invalid number"},
+	UnknownVariable:       {http.StatusUnprocessableEntity, "Неизвестная переменная", "Unknown variable"},
+	InvalidVariable:       {http.StatusUnprocessableEntity, "Недопустимое имя переменной", "Invalid variable name"},
+	ExpressionTooLong:     {http.StatusUnprocessableEntity, "Выражение слишком длинное", "Expression is too long"},
+}
+
+// Error — тело ошибки
+type Error struct {
+	Code      Code    `json:"code"`
+	Message   string  `json:"message"`
+	Details   Details `json:"details,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// Status — HTTP статус ошибки; неизвестный код — 500
+func Status(code Code) int {
+	if e, ok := catalogue[code]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message — текст ошибки на языке lang ("ru" или "en")
+func Message(code Code, lang string) string {
+	e, ok := catalogue[code]
+	if !ok {
+		e = catalogue[InternalError]
+	}
+	if lang == "en" {
+		return e.en
+	}
+	return e.ru
+}
+
+// Codes — все коды каталога по алфавиту
+func Codes() []Code {
+	codes := make([]Code, 0, len(catalogue))
+	for c := range catalogue {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// New собирает ошибку для запроса: язык и request_id берутся из r
+func New(r *http.Request, code Code, details Details) Error {
+	return Error{Code: code, Message: Message(code, Lang(r)), Details: details, RequestID: RequestID(r)}
+}
+
+// Write отвечает ошибкой в едином формате
+func Write(w http.ResponseWriter, r *http.Request, code Code, details Details) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(Status(code))
+	json.NewEncoder(w).Encode(map[string]Error{"error": New(r, code, details)})
+}
+
+// NotFoundHandler отвечает not_found на путь без маршрута
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, NotFound, nil)
+}
+
+// MethodNotAllowedHandler отвечает method_not_allowed, когда маршрут есть,
+// но метод у него другой
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, MethodNotAllowed, nil)
+}
+
+// Lang выбирает язык ответа по Accept-Language с учетом q
+func Lang(r *http.Request) string {
+	best, bestQ := "ru", -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(tag))
+		lang, _, _ = strings.Cut(lang, "-")
+		if lang != "ru" && lang != "en" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+type contextKey struct{}
+
+// RequestIDHeader — заголовок с id запроса в запросе и ответе
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware выдает запросу id: берет X-Request-ID клиента, если он
+// разумной длины, иначе создает новый, и возвращает его в ответе
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 || strings.ContainsAny(id, "\r\n") {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	})
+}
+
+// RequestID — id запроса, выданный Middleware; без Middleware — пустая строка
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(contextKey{}).(string)
+	return id
+}
@@ -8,13 +8,20 @@ import (
 	"strings"
 	"time"
 
+	"calculator/internal/apierror"
+	"calculator/internal/jwtkeys"
+	"calculator/internal/models"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// Ключ для подписи JWT
-var jwtKey = []byte("simple_calculator_key")
+// Ключи для подписи JWT, задаются через SetKeys при старте сервера
+var jwtKeys = jwtkeys.Dev()
+
+// SetKeys задает набор ключей для подписи и проверки JWT
+func SetKeys(ks *jwtkeys.KeySet) {
+	jwtKeys = ks
+}
 
 type contextKey string
 
@@ -27,18 +34,6 @@ type UserClaims struct {
 	jwt.RegisteredClaims
 }
 
-// HashPassword хеширует пароль для безопасного хранения
-func HashPassword(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(hash)
-}
-
-// CheckPassword проверяет, соответствует ли пароль хешу
-func CheckPassword(hash, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 // RegisterHandler — регистрация пользователя
 func RegisterHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -49,11 +44,11 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			Password string `json:"password"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest, nil)
 			return
 		}
 		if req.Login == "" || req.Password == "" {
-			http.Error(w, "login and password required", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.CredentialsRequired, nil)
 			return
 		}
 		
@@ -61,15 +56,19 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 		var count int
 		err := db.QueryRow("SELECT COUNT(*) FROM users WHERE login = ?", req.Login).Scan(&count)
 		if err != nil || count > 0 {
-			http.Error(w, "user already exists", http.StatusConflict)
+			apierror.Write(w, r, apierror.UserExists, nil)
 			return
 		}
 		
 		id := uuid.New().String()
-		hash := HashPassword(req.Password)
+		hash, err := models.HashPassword(req.Password)
+		if err != nil {
+			apierror.Write(w, r, apierror.InternalError, nil)
+			return
+		}
 		_, err = db.Exec("INSERT INTO users (id, login, password) VALUES (?, ?, ?)", id, req.Login, hash)
 		if err != nil {
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.InternalError, nil)
 			return
 		}
 		
@@ -90,21 +89,28 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			Password string `json:"password"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest, nil)
 			return
 		}
 		
 		var id, hash string
 		err := db.QueryRow("SELECT id, password FROM users WHERE login = ?", req.Login).Scan(&id, &hash)
 		if err != nil {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			apierror.Write(w, r, apierror.InvalidCredentials, nil)
 			return
 		}
 		
-		if !CheckPassword(hash, req.Password) {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		if !models.CheckPassword(hash, req.Password) {
+			apierror.Write(w, r, apierror.InvalidCredentials, nil)
 			return
 		}
+
+		// Хеши bcrypt и SHA-256 тихо переводим на argon2id
+		if models.NeedsRehash(hash) {
+			if newHash, err := models.HashPassword(req.Password); err == nil {
+				db.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", newHash, id, hash)
+			}
+		}
 		
 		claims := UserClaims{
 			UserID: id,
@@ -114,10 +120,9 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			},
 		}
 		
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString(jwtKey)
+		signed, err := jwtKeys.Sign(claims)
 		if err != nil {
-			http.Error(w, "could not sign token", http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.InternalError, nil)
 			return
 		}
 		
@@ -131,18 +136,16 @@ func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		if !strings.HasPrefix(header, "Bearer ") {
-			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			apierror.Write(w, r, apierror.Unauthorized, nil)
 			return
 		}
 		
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
 		claims := &UserClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		token, err := jwtKeys.Parse(tokenStr, claims)
 		
 		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+			apierror.Write(w, r, apierror.InvalidToken, nil)
 			return
 		}
 		
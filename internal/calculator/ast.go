@@ -0,0 +1,384 @@
+package calculator
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Типы узлов дерева выражения
+const (
+	NodeNumber   = "number"
+	NodeVariable = "variable"
+	NodeBinary   = "binary"
+	NodeNegate   = "negate"
+)
+
+// Коды синтаксических ошибок
+const (
+	ErrEmptyExpression   = "empty_expression"
+	ErrUnexpectedChar    = "unexpected_character"
+	ErrUnexpectedToken   = "unexpected_token"
+	ErrUnexpectedEnd     = "unexpected_end"
+	ErrUnbalancedParen   = "unbalanced_parenthesis"
+	ErrInvalidNumber     = "invalid_number"
+	ErrUnknownVariable   = "unknown_variable"
+	ErrInvalidVariable   = "invalid_variable"
+	ErrExpressionTooLong = "expression_too_long"
+)
+
+// MaxExpressionLength — наибольшая длина выражения в байтах
+const MaxExpressionLength = 10000
+
+// SyntaxError — ошибка разбора с позицией (смещение в байтах от начала)
+type SyntaxError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Pos     int    `json:"position"`
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("позиция %d: %s", e.Pos, e.Message)
+}
+
+// Node — узел дерева выражения
+type Node struct {
+	Type    string   `json:"type"`
+	Value   *float64 `json:"value,omitempty"`   // number
+	Name    string   `json:"name,omitempty"`    // variable
+	Op      string   `json:"op,omitempty"`      // binary: + - * /
+	Left    *Node    `json:"left,omitempty"`    // binary
+	Right   *Node    `json:"right,omitempty"`   // binary
+	Operand *Node    `json:"operand,omitempty"` // negate
+	Pos     int      `json:"position"`
+}
+
+func numberNode(v float64, pos int) *Node {
+	return &Node{Type: NodeNumber, Value: &v, Pos: pos}
+}
+
+// token — лексема: число, имя, оператор или скобка
+type token struct {
+	kind string // "number", "name", "op", "(", ")", "end"
+	text string
+	pos  int
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case isDigit(ch) || ch == '.':
+			start := i
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{"number", s[start:i], start})
+		case isNameStart(ch):
+			start := i
+			for i < len(s) && (isNameStart(s[i]) || isDigit(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{"name", s[start:i], start})
+		case isOperator(ch):
+			tokens = append(tokens, token{"op", string(ch), i})
+			i++
+		case ch == '(' || ch == ')':
+			tokens = append(tokens, token{string(ch), string(ch), i})
+			i++
+		default:
+			return nil, &SyntaxError{ErrUnexpectedChar, fmt.Sprintf("недопустимый символ %q", rune(ch)), i}
+		}
+	}
+	return append(tokens, token{"end", "", len(s)}), nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isOperator(ch byte) bool {
+	return ch == '+' || ch == '-' || ch == '*' || ch == '/'
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// ValidVariableName — имя переменной: буква или _, затем буквы, цифры, _
+func ValidVariableName(name string) bool {
+	if name == "" || !isNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNameStart(name[i]) && !isDigit(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// astParser — рекурсивный спуск:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = ("-" | "+") unary | primary
+//	primary = number | name | "(" expr ")"
+type astParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *astParser) peek() token { return p.tokens[p.pos] }
+
+func (p *astParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != "end" {
+		p.pos++
+	}
+	return t
+}
+
+// ParseAST разбирает выражение в дерево с учетом приоритета операций и скобок
+func ParseAST(expression string) (*Node, error) {
+	if len(expression) > MaxExpressionLength {
+		return nil, &SyntaxError{ErrExpressionTooLong, fmt.Sprintf("выражение длиннее %d символов", MaxExpressionLength), MaxExpressionLength}
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, &SyntaxError{ErrEmptyExpression, "пустое выражение", 0}
+	}
+	p := &astParser{tokens: tokens}
+	node, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		if t.kind == ")" {
+			return nil, &SyntaxError{ErrUnbalancedParen, "лишняя закрывающая скобка", t.pos}
+		}
+		return nil, &SyntaxError{ErrUnexpectedToken, fmt.Sprintf("неожиданное %q", t.text), t.pos}
+	}
+	return node, nil
+}
+
+func (p *astParser) expr() (*Node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == "op" && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Type: NodeBinary, Op: t.text, Left: left, Right: right, Pos: t.pos}
+	}
+	return left, nil
+}
+
+func (p *astParser) term() (*Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == "op" && (t.text == "*" || t.text == "/"); t = p.peek() {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Type: NodeBinary, Op: t.text, Left: left, Right: right, Pos: t.pos}
+	}
+	return left, nil
+}
+
+func (p *astParser) unary() (*Node, error) {
+	t := p.peek()
+	if t.kind == "op" && (t.text == "-" || t.text == "+") {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return operand, nil
+		}
+		return negate(operand, t.pos), nil
+	}
+	return p.primary()
+}
+
+func (p *astParser) primary() (*Node, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &SyntaxError{ErrInvalidNumber, fmt.Sprintf("неверное число %q", t.text), t.pos}
+		}
+		return numberNode(v, t.pos), nil
+	case "name":
+		return &Node{Type: NodeVariable, Name: t.text, Pos: t.pos}, nil
+	case "(":
+		node, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != ")" {
+			return nil, &SyntaxError{ErrUnbalancedParen, "не закрыта скобка", t.pos}
+		}
+		return node, nil
+	case "end":
+		return nil, &SyntaxError{ErrUnexpectedEnd, "выражение оборвано", t.pos}
+	case ")":
+		return nil, &SyntaxError{ErrUnbalancedParen, "лишняя закрывающая скобка", t.pos}
+	}
+	return nil, &SyntaxError{ErrUnexpectedToken, fmt.Sprintf("неожиданное %q", t.text), t.pos}
+}
+
+// negate сворачивает минус перед числом в само число
+func negate(operand *Node, pos int) *Node {
+	if operand.Type == NodeNumber {
+		return numberNode(-*operand.Value, pos)
+	}
+	return &Node{Type: NodeNegate, Operand: operand, Pos: pos}
+}
+
+// Bind подставляет значения переменных и возвращает новое дерево
+// без переменных. Неизвестная переменная — SyntaxError.
+func Bind(node *Node, vars map[string]float64) (*Node, error) {
+	switch node.Type {
+	case NodeVariable:
+		v, ok := vars[node.Name]
+		if !ok {
+			return nil, &SyntaxError{ErrUnknownVariable, fmt.Sprintf("неизвестная переменная %q", node.Name), node.Pos}
+		}
+		return numberNode(v, node.Pos), nil
+	case NodeNegate:
+		operand, err := Bind(node.Operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		return negate(operand, node.Pos), nil
+	case NodeBinary:
+		left, err := Bind(node.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		right, err := Bind(node.Right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeBinary, Op: node.Op, Left: left, Right: right, Pos: node.Pos}, nil
+	}
+	return node, nil
+}
+
+// String — выражение в нормальной форме: без пробелов и лишних скобок
+func (n *Node) String() string {
+	switch n.Type {
+	case NodeNumber:
+		return strconv.FormatFloat(*n.Value, 'g', -1, 64)
+	case NodeVariable:
+		return n.Name
+	case NodeNegate:
+		if n.Operand.Type == NodeBinary {
+			return "-(" + n.Operand.String() + ")"
+		}
+		return "-" + n.Operand.String()
+	}
+	left, right := n.Left.String(), n.Right.String()
+	if n.Left.Type == NodeBinary && precedence(n.Left.Op) < precedence(n.Op) {
+		left = "(" + left + ")"
+	}
+	// Справа скобки нужны и при равном приоритете: 1-(2-3), 8/(4/2)
+	if n.Right.Type == NodeBinary && precedence(n.Right.Op) <= precedence(n.Op) {
+		right = "(" + right + ")"
+	}
+	if n.Right.Type == NodeNumber && *n.Right.Value < 0 || n.Right.Type == NodeNegate {
+		right = "(" + right + ")"
+	}
+	return left + n.Op + right
+}
+
+func precedence(op string) int {
+	if op == "*" || op == "/" {
+		return 2
+	}
+	return 1
+}
+
+// Arg — аргумент шага: число или результат другого шага
+type Arg struct {
+	Value float64
+	Step  int // индекс шага-источника или -1 для числа
+}
+
+// Step — одна операция графа вычисления
+type Step struct {
+	Operator string
+	Arg1     Arg
+	Arg2     Arg
+}
+
+// Steps раскладывает дерево без переменных на операции в порядке
+// обхода: источники всегда раньше потребителей, корень — последний шаг.
+// Для дерева из одного числа шагов нет, значение возвращается вторым.
+func Steps(node *Node) ([]Step, float64, error) {
+	var steps []Step
+	arg, err := lower(node, &steps)
+	if err != nil {
+		return nil, 0, err
+	}
+	return steps, arg.Value, nil
+}
+
+func lower(node *Node, steps *[]Step) (Arg, error) {
+	switch node.Type {
+	case NodeNumber:
+		return Arg{Value: *node.Value, Step: -1}, nil
+	case NodeVariable:
+		return Arg{}, &SyntaxError{ErrUnknownVariable, fmt.Sprintf("неизвестная переменная %q", node.Name), node.Pos}
+	case NodeNegate:
+		operand, err := lower(node.Operand, steps)
+		if err != nil {
+			return Arg{}, err
+		}
+		*steps = append(*steps, Step{Operator: "-", Arg1: Arg{Value: 0, Step: -1}, Arg2: operand})
+	default:
+		left, err := lower(node.Left, steps)
+		if err != nil {
+			return Arg{}, err
+		}
+		right, err := lower(node.Right, steps)
+		if err != nil {
+			return Arg{}, err
+		}
+		*steps = append(*steps, Step{Operator: node.Op, Arg1: left, Arg2: right})
+	}
+	return Arg{Step: len(*steps) - 1}, nil
+}
+
+// Compile разбирает выражение, подставляет переменные и строит шаги
+func Compile(expression string, vars map[string]float64) (*Node, []Step, float64, error) {
+	tree, err := ParseAST(expression)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	bound, err := Bind(tree, vars)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	steps, value, err := Steps(bound)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return tree, steps, value, nil
+}
@@ -0,0 +1,96 @@
+package calculator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAST(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+	}{
+		{name: "приоритет умножения", expression: "2+2*3", want: "2+2*3"},
+		{name: "скобки", expression: "(2+2)*3", want: "(2+2)*3"},
+		{name: "лишние скобки убираются", expression: "((2)) + (3*4)", want: "2+3*4"},
+		{name: "левая ассоциативность", expression: "10-(2-3)-4", want: "10-(2-3)-4"},
+		{name: "многозначные числа", expression: "12*34/56", want: "12*34/56"},
+		{name: "унарный минус у числа", expression: "-2*-3", want: "-2*(-3)"},
+		{name: "унарный минус у скобки", expression: "-(1+x)", want: "-(1+x)"},
+		{name: "дробные числа и переменные", expression: "rate * 2.5 + _base1", want: "rate*2.5+_base1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := ParseAST(tt.expression)
+			if err != nil {
+				t.Fatalf("ошибка разбора: %v", err)
+			}
+			if got := node.String(); got != tt.want {
+				t.Errorf("получено %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseASTErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		code       string
+		pos        int
+	}{
+		{name: "пустое выражение", expression: "  ", code: ErrEmptyExpression, pos: 0},
+		{name: "оборванное выражение", expression: "2+", code: ErrUnexpectedEnd, pos: 2},
+		{name: "недопустимый символ", expression: "2 % 3", code: ErrUnexpectedChar, pos: 2},
+		{name: "не закрыта скобка", expression: "(2+3", code: ErrUnbalancedParen, pos: 0},
+		{name: "лишняя скобка", expression: "2+3)", code: ErrUnbalancedParen, pos: 3},
+		{name: "два оператора", expression: "2*/3", code: ErrUnexpectedToken, pos: 2},
+		{name: "два числа подряд", expression: "2 3", code: ErrUnexpectedToken, pos: 2},
+		{name: "неверное число", expression: "1.2.3+1", code: ErrInvalidNumber, pos: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAST(tt.expression)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ожидалась SyntaxError, получено %v", err)
+			}
+			if syntaxErr.Code != tt.code || syntaxErr.Pos != tt.pos {
+				t.Errorf("получено %s в позиции %d, ожидалось %s в позиции %d", syntaxErr.Code, syntaxErr.Pos, tt.code, tt.pos)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	_, _, _, err := Compile("x+y", map[string]float64{"x": 1})
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Code != ErrUnknownVariable || syntaxErr.Pos != 2 {
+		t.Errorf("неизвестная переменная: %v", err)
+	}
+
+	_, steps, value, err := Compile("-x", map[string]float64{"x": 4})
+	if err != nil || len(steps) != 0 || value != -4 {
+		t.Errorf("выражение без операций: %v %v %v", steps, value, err)
+	}
+
+	// (a+b)*(c-d): две независимые операции и умножение их результатов
+	_, steps, _, err = Compile("(a+b)*(c-d)", map[string]float64{"a": 1, "b": 2, "c": 5, "d": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Step{
+		{Operator: "+", Arg1: Arg{Value: 1, Step: -1}, Arg2: Arg{Value: 2, Step: -1}},
+		{Operator: "-", Arg1: Arg{Value: 5, Step: -1}, Arg2: Arg{Value: 3, Step: -1}},
+		{Operator: "*", Arg1: Arg{Step: 0}, Arg2: Arg{Step: 1}},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("шаги: %+v", steps)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("шаг %d: %+v, ожидалось %+v", i, steps[i], want[i])
+		}
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"strings"
 )
 
+// ErrDivisionByZero — деление на ноль
+var ErrDivisionByZero = errors.New("деление на ноль")
+
 // Calculator представляет собой калькулятор для арифметических выражений
-type Calculator struct {
-	parser *Parser
-}
+type Calculator struct{}
 
 // NewCalculator создает новый экземпляр калькулятора
 func NewCalculator() *Calculator {
@@ -74,7 +75,7 @@ func (c *Calculator) Calculate(arg1, arg2 float64, operator string) (float64, er
 		return arg1 * arg2, nil
 	case "/":
 		if arg2 == 0 {
-			return 0, errors.New("деление на ноль")
+			return 0, ErrDivisionByZero
 		}
 		return arg1 / arg2, nil
 	default:
@@ -91,3 +92,35 @@ func (c *Calculator) EvaluateExpression(expression string) (float64, error) {
 
 	return c.Calculate(arg1, arg2, operator)
 }
+
+// Evaluate вычисляет выражение со скобками и переменными локально,
+// по тем же шагам, что оркестратор раздает агентам
+func (c *Calculator) Evaluate(expression string, vars map[string]float64) (float64, error) {
+	_, steps, value, err := Compile(expression, vars)
+	if err != nil {
+		return 0, err
+	}
+	return c.Run(steps, value)
+}
+
+// Run выполняет шаги, построенные Steps или Compile
+func (c *Calculator) Run(steps []Step, value float64) (float64, error) {
+	results := make([]float64, len(steps))
+	arg := func(a Arg) float64 {
+		if a.Step >= 0 {
+			return results[a.Step]
+		}
+		return a.Value
+	}
+	for i, step := range steps {
+		result, err := c.Calculate(arg(step.Arg1), arg(step.Arg2), step.Operator)
+		if err != nil {
+			return 0, err
+		}
+		results[i] = result
+	}
+	if len(steps) == 0 {
+		return value, nil
+	}
+	return results[len(results)-1], nil
+}
@@ -270,3 +270,30 @@ func TestIntegrationParseAndCalculate(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluate(t *testing.T) {
+	calculator := NewCalculator()
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]float64
+		want       float64
+		wantErr    error
+	}{
+		{name: "приоритет операций", expression: "2+2*2", want: 6},
+		{name: "скобки и переменные", expression: "(a+b)*-c", vars: map[string]float64{"a": 1, "b": 2, "c": 4}, want: -12},
+		{name: "одно число", expression: "42", want: 42},
+		{name: "деление на ноль", expression: "1/(2-2)", wantErr: ErrDivisionByZero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculator.Evaluate(tt.expression, tt.vars)
+			if err != tt.wantErr {
+				t.Fatalf("ошибка: %v, ожидалось %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("получено %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
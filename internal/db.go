@@ -6,5 +6,8 @@ import (
 )
 
 func OpenDB(path string) (*sql.DB, error) {
-	return sql.Open("sqlite3", path)
+	// busy_timeout — ждать блокировку вместо SQLITE_BUSY; immediate —
+	// транзакции сразу берут блокировку записи, иначе параллельные
+	// результаты задач одного выражения конфликтуют при апгрейде блокировки
+	return sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
 }
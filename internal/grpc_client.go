@@ -2,11 +2,15 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"time"
 
 	"calculator/calculatorpb"
+	"calculator/internal/agentauth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type AgentGRPCClient struct {
@@ -14,16 +18,24 @@ type AgentGRPCClient struct {
 	conn   *grpc.ClientConn
 }
 
-func NewAgentGRPCClient(addr string) (*AgentGRPCClient, error) {
+// NewAgentGRPCClient подключается к оркестратору; token передается
+// с каждым вызовом для аутентификации агента. tlsConf == nil — без TLS.
+func NewAgentGRPCClient(addr, token string, tlsConf *tls.Config) (*AgentGRPCClient, error) {
 	// Вместо устаревшего WithTimeout используем контекст с таймаутом
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
+	transport := insecure.NewCredentials()
+	if tlsConf != nil {
+		transport = credentials.NewTLS(tlsConf)
+	}
+
 	// Используем контекст для подключения
 	conn, err := grpc.DialContext(
 		ctx,
 		addr, 
-		grpc.WithInsecure(),
+		grpc.WithTransportCredentials(transport),
+		grpc.WithPerRPCCredentials(agentauth.Credentials(token, tlsConf != nil)),
 		grpc.WithBlock(),
 	)
 	if err != nil {
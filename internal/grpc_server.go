@@ -2,41 +2,72 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"net"
 
 	"calculator/calculatorpb"
+	"calculator/internal/agentauth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 )
 
 type AgentServiceServerImpl struct {
 	calculatorpb.UnimplementedAgentServiceServer
-	TaskProvider func() (*calculatorpb.Task, bool)
-	ResultHandler func(taskID int64, result float64) error
+	TaskProvider func(agentID string) (*calculatorpb.Task, bool)
+	ResultHandler func(agentID string, taskID int64, result float64) error
+}
+
+// agentID возвращает имя агента, под которым он прошел аутентификацию
+func agentID(ctx context.Context) string {
+	if name, ok := agentauth.Identity(ctx); ok {
+		return name
+	}
+	return "unknown"
+}
+
+// peerAddr — адрес соединения агента, для логов
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
 }
 
 func (s *AgentServiceServerImpl) GetTask(ctx context.Context, req *calculatorpb.GetTaskRequest) (*calculatorpb.GetTaskResponse, error) {
-	task, ok := s.TaskProvider()
+	task, ok := s.TaskProvider(agentID(ctx))
 	if !ok || task == nil {
 		return &calculatorpb.GetTaskResponse{HasTask: false}, nil
 	}
+	log.Printf("Задача %d выдана агенту %s (%s)", task.Id, agentID(ctx), peerAddr(ctx))
 	return &calculatorpb.GetTaskResponse{HasTask: true, Task: task}, nil
 }
 
 func (s *AgentServiceServerImpl) SendResult(ctx context.Context, req *calculatorpb.SendResultRequest) (*calculatorpb.SendResultResponse, error) {
-	err := s.ResultHandler(req.TaskId, req.Result)
+	log.Printf("Результат задачи %d от агента %s (%s)", req.TaskId, agentID(ctx), peerAddr(ctx))
+	err := s.ResultHandler(agentID(ctx), req.TaskId, req.Result)
 	if err != nil {
 		return &calculatorpb.SendResultResponse{Ok: false, Error: err.Error()}, nil
 	}
 	return &calculatorpb.SendResultResponse{Ok: true}, nil
 }
 
-func StartGRPCServer(taskProvider func() (*calculatorpb.Task, bool), resultHandler func(taskID int64, result float64) error, port string) {
+// StartGRPCServer запускает AgentService. Вызовы без действительного
+// токена агента или сертификата отклоняются с кодом Unauthenticated.
+// tlsConf == nil — соединение без шифрования (только для разработки).
+func StartGRPCServer(taskProvider func(agentID string) (*calculatorpb.Task, bool), resultHandler func(agentID string, taskID int64, result float64) error, port string, tokens *agentauth.Tokens, tlsConf *tls.Config) {
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(tokens.UnaryServerInterceptor())}
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	} else {
+		log.Printf("ВНИМАНИЕ: gRPC сервер работает без TLS")
+	}
+	grpcServer := grpc.NewServer(opts...)
 	srv := &AgentServiceServerImpl{TaskProvider: taskProvider, ResultHandler: resultHandler}
 	calculatorpb.RegisterAgentServiceServer(grpcServer, srv)
 	log.Printf("gRPC сервер запущен на порту %s", port)
@@ -26,7 +26,7 @@ func (s *mockAgentServer) SendResult(ctx context.Context, req *calculatorpb.Send
 // Тест для проверки создания gRPC клиента агента
 func TestAgentGRPCClient(t *testing.T) {
 	// Тест создания клиента с неверным адресом
-	_, err := NewAgentGRPCClient("invalid:address")
+	_, err := NewAgentGRPCClient("invalid:address", "token", nil)
 	if err == nil {
 		t.Error("Ожидалась ошибка при подключении к некорректному адресу, но ошибки не было")
 	}
@@ -0,0 +1,329 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DevSecret используется только в режиме разработки, когда секрет не задан
+const DevSecret = "dev_insecure_jwt_secret"
+
+// MinSecretLength — минимальная длина секрета в production режиме
+const MinSecretLength = 32
+
+// MinRSABits — минимальный размер RSA ключа в production режиме
+const MinRSABits = 2048
+
+// weakSecrets — значения, которые когда-то были захардкожены в репозитории
+// и поэтому не должны использоваться в production
+var weakSecrets = map[string]bool{
+	DevSecret:               true,
+	"super_secret_key":      true,
+	"simple_calculator_key": true,
+	"secret":                true,
+	"changeme":              true,
+}
+
+// Key — один ключ подписи с идентификатором (kid).
+// Для HS256 заполняется Secret, для RS256 и EdDSA — Public и,
+// если ключ используется для подписи, Private.
+type Key struct {
+	ID      string
+	Secret  []byte
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// Method возвращает алгоритм подписи, с которым связан ключ
+func (k Key) Method() jwt.SigningMethod {
+	switch k.Public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodHS256
+}
+
+func (k Key) canSign() bool {
+	if len(k.Secret) > 0 {
+		return true
+	}
+	return k.Private != nil
+}
+
+func (k Key) signKey() interface{} {
+	if len(k.Secret) > 0 {
+		return k.Secret
+	}
+	return k.Private
+}
+
+func (k Key) verifyKey() interface{} {
+	if len(k.Secret) > 0 {
+		return k.Secret
+	}
+	return k.Public
+}
+
+// KeySet хранит активный ключ для подписи и все ключи, которыми
+// можно проверять токены. Это позволяет менять ключ без разлогина
+// пользователей: новый ключ становится активным, старый остается
+// в наборе до истечения выданных им токенов.
+type KeySet struct {
+	active string
+	keys   map[string]Key
+}
+
+// NewKeySet создает набор ключей, активным становится activeID
+func NewKeySet(activeID string, keys ...Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwtkeys: no keys configured")
+	}
+	ks := &KeySet{active: activeID, keys: make(map[string]Key, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("jwtkeys: key id is empty")
+		}
+		if len(k.Secret) == 0 && k.Public == nil {
+			return nil, fmt.Errorf("jwtkeys: key %q has no key material", k.ID)
+		}
+		if _, dup := ks.keys[k.ID]; dup {
+			return nil, fmt.Errorf("jwtkeys: duplicate key id %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+	key, ok := ks.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("jwtkeys: active key %q not found", activeID)
+	}
+	if !key.canSign() {
+		return nil, fmt.Errorf("jwtkeys: active key %q has no private key", activeID)
+	}
+	return ks, nil
+}
+
+// Dev возвращает набор из одного ключа для разработки и тестов
+func Dev() *KeySet {
+	ks, _ := NewKeySet("dev", Key{ID: "dev", Secret: []byte(DevSecret)})
+	return ks
+}
+
+// ActiveID возвращает kid ключа, которым подписываются новые токены
+func (ks *KeySet) ActiveID() string {
+	return ks.active
+}
+
+// Sign подписывает claims активным ключом и проставляет kid в заголовок
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.active]
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey())
+}
+
+// Keyfunc выбирает ключ проверки по kid из заголовка токена.
+// Токены без kid проверяются активным ключом. Алгоритм токена
+// должен совпадать с алгоритмом ключа: иначе публичный RSA ключ
+// можно было бы подсунуть как секрет HS256.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = ks.active
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method().Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+	}
+	return key.verifyKey(), nil
+}
+
+// Parse проверяет подпись токена и заполняет claims
+func (ks *KeySet) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenStr, claims, ks.Keyfunc, jwt.WithValidMethods(ks.methods()))
+}
+
+// methods возвращает алгоритмы всех ключей набора
+func (ks *KeySet) methods() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range ks.keys {
+		alg := k.Method().Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// Validate проверяет, что набор ключей можно использовать в production
+func (ks *KeySet) Validate() error {
+	for _, k := range ks.keys {
+		if pub, ok := k.Public.(*rsa.PublicKey); ok {
+			if pub.N.BitLen() < MinRSABits {
+				return fmt.Errorf("jwtkeys: RSA key %q is shorter than %d bits", k.ID, MinRSABits)
+			}
+			continue
+		}
+		if k.Public != nil {
+			continue
+		}
+		if weakSecrets[string(k.Secret)] {
+			return fmt.Errorf("jwtkeys: key %q uses a default secret", k.ID)
+		}
+		if len(k.Secret) < MinSecretLength {
+			return fmt.Errorf("jwtkeys: key %q is shorter than %d bytes", k.ID, MinSecretLength)
+		}
+	}
+	return nil
+}
+
+// Config описывает, откуда загружать ключи
+type Config struct {
+	Production bool   // APP_ENV=production
+	Secret     string // JWT_SECRET — один секрет
+	SecretFile string // JWT_SECRET_FILE — файл с одним секретом
+	KeyID      string // JWT_KEY_ID — kid для JWT_SECRET / JWT_SECRET_FILE
+	KeysFile   string // JWT_KEYS_FILE — файл со строками "kid=secret"
+	ActiveID   string // JWT_ACTIVE_KID — какой ключ из файла активный
+
+	PrivateKeyFile string   // JWT_PRIVATE_KEY_FILE — PEM ключ RSA или Ed25519 для подписи
+	PublicKeyFiles []string // JWT_PUBLIC_KEY_FILES — PEM ключи только для проверки (через запятую)
+}
+
+// ConfigFromEnv читает настройки ключей из переменных окружения
+func ConfigFromEnv() Config {
+	return Config{
+		Production: strings.EqualFold(os.Getenv("APP_ENV"), "production"),
+		Secret:     os.Getenv("JWT_SECRET"),
+		SecretFile: os.Getenv("JWT_SECRET_FILE"),
+		KeyID:      os.Getenv("JWT_KEY_ID"),
+		KeysFile:   os.Getenv("JWT_KEYS_FILE"),
+		ActiveID:   os.Getenv("JWT_ACTIVE_KID"),
+
+		PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		PublicKeyFiles: splitList(os.Getenv("JWT_PUBLIC_KEY_FILES")),
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Load собирает набор ключей по конфигурации. Если ничего не задано,
+// в режиме разработки возвращается Dev(), а в production — ошибка.
+func Load(cfg Config) (*KeySet, error) {
+	var keys []Key
+	active := cfg.ActiveID
+
+	if cfg.PrivateKeyFile != "" {
+		key, err := readPrivateKeyFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if active == "" {
+			active = key.ID
+		}
+	}
+	for _, path := range cfg.PublicKeyFiles {
+		key, err := readPublicKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Публичная часть уже загруженного закрытого ключа ничего не добавляет
+		if hasPublicKey(keys, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if cfg.KeysFile != "" {
+		fileKeys, err := readKeysFile(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+		if active == "" && len(fileKeys) > 0 {
+			active = fileKeys[0].ID
+		}
+	}
+
+	secret := cfg.Secret
+	if cfg.SecretFile != "" {
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwtkeys: read secret file: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret != "" {
+		id := cfg.KeyID
+		if id == "" {
+			id = "primary"
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+		if active == "" {
+			active = id
+		}
+	}
+
+	if len(keys) == 0 {
+		if cfg.Production {
+			return nil, errors.New("jwtkeys: JWT_PRIVATE_KEY_FILE, JWT_SECRET, JWT_SECRET_FILE or JWT_KEYS_FILE must be set in production")
+		}
+		log.Printf("ВНИМАНИЕ: секрет JWT не задан, используется ключ для разработки")
+		return Dev(), nil
+	}
+
+	ks, err := NewKeySet(active, keys...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Production {
+		if err := ks.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// readKeysFile читает файл со строками "kid=secret".
+// Пустые строки и строки, начинающиеся с #, пропускаются.
+func readKeysFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwtkeys: read keys file: %w", err)
+	}
+	var keys []Key
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, secret, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("jwtkeys: %s:%d: expected kid=secret", path, n+1)
+		}
+		keys = append(keys, Key{ID: strings.TrimSpace(id), Secret: []byte(strings.TrimSpace(secret))})
+	}
+	return keys, nil
+}
@@ -0,0 +1,105 @@
+package jwtkeys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestSignSetsKidAndVerifies(t *testing.T) {
+	ks, err := NewKeySet("k1", Key{ID: "k1", Secret: []byte("secret-one")})
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	signed, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	var claims jwt.RegisteredClaims
+	token, err := ks.Parse(signed, &claims)
+	if err != nil || !token.Valid {
+		t.Fatalf("токен не прошел проверку: %v", err)
+	}
+	if token.Header["kid"] != "k1" {
+		t.Errorf("kid = %v, ожидалось k1", token.Header["kid"])
+	}
+}
+
+func TestRotationKeepsOldTokensValid(t *testing.T) {
+	oldKey := Key{ID: "2024", Secret: []byte("old-secret")}
+	newKey := Key{ID: "2025", Secret: []byte("new-secret")}
+
+	before, _ := NewKeySet("2024", oldKey)
+	oldToken, _ := before.Sign(testClaims())
+
+	after, err := NewKeySet("2025", newKey, oldKey)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	if _, err := after.Parse(oldToken, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("старый токен должен проверяться после ротации: %v", err)
+	}
+
+	// После удаления старого ключа его токены больше не принимаются
+	retired, _ := NewKeySet("2025", newKey)
+	if _, err := retired.Parse(oldToken, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("токен удаленного ключа не должен проходить проверку")
+	}
+}
+
+func TestLoadKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# ключи\nnew=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n\nold=bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := Load(Config{KeysFile: path, Production: true})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if ks.ActiveID() != "new" {
+		t.Errorf("активный ключ = %s, ожидался new", ks.ActiveID())
+	}
+
+	ks, err = Load(Config{KeysFile: path, ActiveID: "old"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if ks.ActiveID() != "old" {
+		t.Errorf("активный ключ = %s, ожидался old", ks.ActiveID())
+	}
+}
+
+func TestLoadProductionRejectsDefaults(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  Config
+	}{
+		{"NoSecret", Config{Production: true}},
+		{"OldHardcodedKey", Config{Production: true, Secret: "super_secret_key"}},
+		{"TooShort", Config{Production: true, Secret: "short"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Load(tc.cfg); err == nil {
+				t.Error("ожидалась ошибка в production режиме")
+			}
+		})
+	}
+
+	if _, err := Load(Config{}); err != nil {
+		t.Errorf("в режиме разработки ключ по умолчанию допустим: %v", err)
+	}
+}
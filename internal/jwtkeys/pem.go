@@ -0,0 +1,107 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ParsePrivateKeyPEM разбирает PEM ключ RSA или Ed25519.
+// kid вычисляется как отпечаток публичного ключа (RFC 7638).
+func ParsePrivateKeyPEM(data []byte) (Key, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return asymmetricKey(rsaKey, &rsaKey.PublicKey)
+	}
+	edKey, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("jwtkeys: private key is neither RSA nor Ed25519")
+	}
+	signer, ok := edKey.(ed25519.PrivateKey)
+	if !ok {
+		return Key{}, fmt.Errorf("jwtkeys: unsupported private key type %T", edKey)
+	}
+	return asymmetricKey(signer, signer.Public())
+}
+
+// ParsePublicKeyPEM разбирает публичный PEM ключ RSA или Ed25519.
+// Такой ключ годится только для проверки подписи.
+func ParsePublicKeyPEM(data []byte) (Key, error) {
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return asymmetricKey(nil, rsaKey)
+	}
+	edKey, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("jwtkeys: public key is neither RSA nor Ed25519")
+	}
+	return asymmetricKey(nil, edKey)
+}
+
+func asymmetricKey(private crypto.Signer, public crypto.PublicKey) (Key, error) {
+	kid, err := Thumbprint(public)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{ID: kid, Private: private, Public: public}, nil
+}
+
+func readPrivateKeyFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("jwtkeys: read private key: %w", err)
+	}
+	key, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%w (%s)", err, path)
+	}
+	return key, nil
+}
+
+// hasPublicKey — есть ли среди keys ключ с тем же публичным ключом. kid
+// асимметричного ключа — отпечаток публичного, поэтому хватает сравнить kid.
+func hasPublicKey(keys []Key, key Key) bool {
+	for _, k := range keys {
+		if k.Public != nil && k.ID == key.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func readPublicKeyFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("jwtkeys: read public key: %w", err)
+	}
+	key, err := ParsePublicKeyPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%w (%s)", err, path)
+	}
+	return key, nil
+}
+
+// Thumbprint вычисляет отпечаток JWK по RFC 7638
+func Thumbprint(public crypto.PublicKey) (string, error) {
+	var canonical string
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			b64(big.NewInt(int64(pub.E)).Bytes()), b64(pub.N.Bytes()))
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, b64(pub))
+	default:
+		return "", fmt.Errorf("jwtkeys: unsupported public key type %T", public)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:]), nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,128 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadEd25519PrivateKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := writePEM(t, "ed.pem", "PRIVATE KEY", der)
+
+	ks, err := Load(Config{PrivateKeyFile: path, Production: true})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	signed, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	token, err := ks.Parse(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if token.Method.Alg() != "EdDSA" {
+		t.Errorf("alg = %s, ожидался EdDSA", token.Method.Alg())
+	}
+
+	set := ks.JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kty != "OKP" || set.Keys[0].Kid != ks.ActiveID() {
+		t.Errorf("неверный JWKS: %+v", set)
+	}
+}
+
+func TestRSAPublicKeyOnlyVerifies(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, err := asymmetricKey(priv, &priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, _ := NewKeySet(signing.ID, signing)
+	signed, _ := issuer.Sign(testClaims())
+
+	pubDER, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	path := writePEM(t, "rsa.pub", "PUBLIC KEY", pubDER)
+
+	// Проверяющая сторона знает только публичный ключ и свой секрет
+	verifier, err := Load(Config{Secret: "local-secret", PublicKeyFiles: []string{path}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := verifier.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("RS256 токен должен проверяться публичным ключом: %v", err)
+	}
+
+	if _, err := NewKeySet(signing.ID, Key{ID: signing.ID, Public: &priv.PublicKey}); err == nil {
+		t.Error("ключ без приватной части не может быть активным")
+	}
+}
+
+// Закрытый ключ и его же публичный в JWT_PUBLIC_KEY_FILES — один kid;
+// это один ключ, а не конфликт
+func TestPublicKeyOfPrivateKeySkipped(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	privPath := writePEM(t, "ed.pem", "PRIVATE KEY", der)
+	pubDER, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	pubPath := writePEM(t, "ed.pub", "PUBLIC KEY", pubDER)
+
+	ks, err := Load(Config{PrivateKeyFile: privPath, PublicKeyFiles: []string{pubPath}, Production: true})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := ks.Sign(testClaims()); err != nil {
+		t.Errorf("закрытый ключ должен остаться в наборе: %v", err)
+	}
+	if set := ks.JWKS(); len(set.Keys) != 1 {
+		t.Errorf("в JWKS %d ключей, ожидался 1", len(set.Keys))
+	}
+}
+
+func TestAlgorithmConfusionRejected(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := asymmetricKey(priv, &priv.PublicKey)
+	ks, _ := NewKeySet(key.ID, key)
+
+	// Атакующий подписывает HS256 токен публичным ключом как секретом
+	pubDER, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = key.ID
+	forgedStr, _ := forged.SignedString(pubPEM)
+
+	if _, err := ks.Parse(forgedStr, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("HS256 токен с kid RSA ключа должен отклоняться")
+	}
+
+	if len(ks.JWKS().Keys) != 1 {
+		t.Error("в JWKS должен быть ровно один публичный ключ")
+	}
+	if len(Dev().JWKS().Keys) != 0 {
+		t.Error("секреты HS256 не должны попадать в JWKS")
+	}
+}
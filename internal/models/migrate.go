@@ -21,9 +21,300 @@ func Migrate(db *sql.DB) error {
 		user_id TEXT NOT NULL,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
+
+	-- refresh токены хранятся только в виде хеша; family_id объединяет
+	-- цепочку ротаций, чтобы при повторном использовании отозвать всю цепочку
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		family_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME,
+		revoked_at DATETIME,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+
+	-- отозванные access токены (jti) до истечения их срока действия
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at DATETIME NOT NULL
+	);
+
+	-- стоимость (время выполнения, мс) операций, измененная через admin API
+	CREATE TABLE IF NOT EXISTS operation_costs (
+		operation TEXT PRIMARY KEY,
+		time_ms INTEGER NOT NULL
+	);
+
+	-- персональные API ключи; хранится только хеш ключа,
+	-- scopes — области доступа через запятую
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+	-- право другого пользователя читать выражение
+	CREATE TABLE IF NOT EXISTS expression_grants (
+		expression_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		granted_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (expression_id, user_id),
+		FOREIGN KEY(expression_id) REFERENCES expressions(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_expression_grants_user ON expression_grants(user_id);
+
+	-- публичные ссылки на выражения; хранится только хеш токена
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		expression_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		FOREIGN KEY(expression_id) REFERENCES expressions(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_share_links_expression ON share_links(expression_id);
+
+	-- задачи выражений: время постановки в очередь, выдачи агенту и результата
+	CREATE TABLE IF NOT EXISTS tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		expression_id TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		arg1 REAL NOT NULL,
+		arg2 REAL NOT NULL,
+		operation_time INTEGER NOT NULL,
+		enqueued_at DATETIME NOT NULL,
+		leased_at DATETIME,
+		agent_id TEXT,
+		completed_at DATETIME,
+		result REAL,
+		FOREIGN KEY(expression_id) REFERENCES expressions(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_tasks_expression ON tasks(expression_id);
+
+	-- пакеты выражений, отправленные одним запросом
+	CREATE TABLE IF NOT EXISTS batches (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		item_count INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+
+	-- ключи идемпотентности POST /api/v1/calculate и ответы на первый
+	-- запрос с ключом; status_code NULL — запрос еще выполняется
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER,
+		response BLOB,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+
+	-- вебхуки пользователя; секрет хранится открытым, им подписываются запросы
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);
+
+	-- журнал доставки вебхуков; status: pending, delivered, dead
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		expression_id TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME,
+		last_status INTEGER,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+
+	-- счетчики неудачных входов; key — "login:<логин>" или "ip:<адрес>"
+	CREATE TABLE IF NOT EXISTS login_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		locked_until DATETIME,
+		last_failure DATETIME NOT NULL
+	);
 	`)
 	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
+
+	// Колонки, добавленные после первой версии схемы. В SQLite у ALTER TABLE
+	// нет IF NOT EXISTS, поэтому сначала проверяем, есть ли колонка.
+	columns := []struct{ table, name, def string }{
+		{"users", "created_at", "DATETIME"},
+		{"users", "updated_at", "DATETIME"},
+		{"users", "tokens_valid_after", "DATETIME"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"users", "disabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "created_at", "DATETIME"},
+		{"expressions", "started_at", "DATETIME"},
+		{"expressions", "completed_at", "DATETIME"},
+		{"expressions", "task_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "tasks_done", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "compute_ms", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "error", "TEXT"},
+		{"expressions", "variables", "TEXT"},
+		{"expressions", "batch_id", "TEXT"},
+		{"expressions", "client_ref", "TEXT"},
+		{"expressions", "title", "TEXT"},
+		{"expressions", "notes", "TEXT"},
+		{"expressions", "tags", "TEXT"},
+		{"tasks", "arg1_task", "INTEGER"},
+		{"tasks", "arg2_task", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.name, c.def); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+	}
+	_, err = db.Exec(`
+	UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+	UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
+	-- формат совпадает с тем, в котором драйвер пишет time.Time, иначе
+	-- сравнение строк в keyset пагинации дает неверный порядок
+	UPDATE expressions SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now') WHERE created_at IS NULL;
+
+	-- индексы для постраничного списка выражений пользователя
+	CREATE INDEX IF NOT EXISTS idx_expressions_user_created ON expressions(user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_expressions_user_status ON expressions(user_id, status, created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_expressions_user_result ON expressions(user_id, result, id);
+	CREATE INDEX IF NOT EXISTS idx_expressions_batch ON expressions(batch_id) WHERE batch_id IS NOT NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if err := migrateSearch(db); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
 	return nil
 }
+
+// ftsTriggers — триггеры, которые держат expressions_fts в соответствии
+// с expressions. Статус и результат меняются часто, поэтому триггер
+// обновления срабатывает только на изменение индексируемых колонок.
+var ftsTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS expressions_fts_insert AFTER INSERT ON expressions BEGIN
+		INSERT INTO expressions_fts(rowid, expression, title, notes) VALUES (new.rowid, new.expression, new.title, new.notes);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS expressions_fts_delete AFTER DELETE ON expressions BEGIN
+		INSERT INTO expressions_fts(expressions_fts, rowid, expression, title, notes) VALUES ('delete', old.rowid, old.expression, old.title, old.notes);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS expressions_fts_update AFTER UPDATE OF expression, title, notes ON expressions BEGIN
+		INSERT INTO expressions_fts(expressions_fts, rowid, expression, title, notes) VALUES ('delete', old.rowid, old.expression, old.title, old.notes);
+		INSERT INTO expressions_fts(rowid, expression, title, notes) VALUES (new.rowid, new.expression, new.title, new.notes);
+	END`,
+}
+
+// FullTextSearch — собран ли драйвер с FTS5. go-sqlite3 включает модуль
+// только при сборке с тегом sqlite_fts5; без него поиск идет через LIKE.
+func FullTextSearch(db *sql.DB) bool {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false
+	}
+	return enabled
+}
+
+// migrateSearch создает полнотекстовый индекс expressions_fts по тексту
+// выражения, заголовку и заметкам. Сборка без FTS5 не может обновлять
+// индекс, поэтому снимает его триггеры; следующий запуск с FTS5 вернет
+// триггеры и перестроит индекс целиком.
+//
+// Индекс ссылается на строки expressions по rowid. Первичный ключ у
+// expressions текстовый, поэтому VACUUM может перенумеровать rowid, и
+// индекс начнет указывать не на те строки. При старте он сверяется с
+// таблицей и при расхождении перестраивается.
+func migrateSearch(db *sql.DB) error {
+	if !FullTextSearch(db) {
+		for _, name := range []string{"expressions_fts_insert", "expressions_fts_delete", "expressions_fts_update"} {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var triggers int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'trigger' AND tbl_name = 'expressions' AND name LIKE 'expressions_fts_%'`).Scan(&triggers); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS expressions_fts USING fts5(
+		expression, title, notes, content='expressions', content_rowid='rowid', tokenize='unicode61')`)
+	if err != nil {
+		return err
+	}
+	for _, trigger := range ftsTriggers {
+		if _, err := db.Exec(trigger); err != nil {
+			return err
+		}
+	}
+	if triggers == len(ftsTriggers) {
+		_, err = db.Exec("INSERT INTO expressions_fts(expressions_fts, rank) VALUES ('integrity-check', 1)")
+		if err == nil {
+			return nil
+		}
+	}
+	_, err = db.Exec("INSERT INTO expressions_fts(expressions_fts) VALUES ('rebuild')")
+	return err
+}
+
+// addColumn добавляет колонку, если ее еще нет в таблице
+func addColumn(db *sql.DB, table, column, def string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err
+}
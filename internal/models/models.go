@@ -1,11 +1,14 @@
 package models
 
+import "time"
+
 type CalculationStatus string
 
 const (
 	StatusPending    CalculationStatus = "pending"    // ждем выполнения
 	StatusProcessing CalculationStatus = "processing" // выполняется
 	StatusCompleted  CalculationStatus = "completed"  // ура, готово
+	StatusFailed     CalculationStatus = "failed"     // операция дала недопустимый результат
 )
 
 type Expression struct {
@@ -13,11 +16,32 @@ type Expression struct {
 	Expression string            `json:"expression,omitempty"`
 	Status     CalculationStatus `json:"status"`
 	Result     *float64          `json:"result,omitempty"`
-	UserID     string            `json:"user_id" db:"user_id"`
+	UserID     string            `json:"user_id,omitempty" db:"user_id"`
+	CreatedAt  *time.Time        `json:"created_at,omitempty"`
+	// Первая выдача задачи агенту и получение последнего результата
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	TaskCount   int        `json:"task_count"`
+	TasksDone   int        `json:"tasks_done"`
+	ComputeMS   int64      `json:"compute_ms"`      // суммарное время агентов от выдачи до результата
+	Error       string     `json:"error,omitempty"` // причина статуса failed
+	// Значения переменных выражения и пакет, в котором оно отправлено
+	Variables map[string]float64 `json:"variables,omitempty"`
+	BatchID   string             `json:"batch_id,omitempty"`
+	Ref       string             `json:"ref,omitempty"` // ссылка клиента на элемент пакета
+	// Заметки пользователя; меняются через PATCH, ищутся через /search
+	Title string   `json:"title,omitempty"`
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
 }
 
 type CalculationRequest struct {
-	Expression string `json:"expression"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Ref        string             `json:"ref,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Notes      string             `json:"notes,omitempty"`
+	Tags       []string           `json:"tags,omitempty"`
 }
 
 type Task struct {
@@ -26,9 +50,11 @@ type Task struct {
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int64   `json:"operation_time"`
+	AgentID       string  `json:"agent_id,omitempty"` // агент, получивший задачу
 }
 
 type TaskResult struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
+	ID      string  `json:"id"`
+	Result  float64 `json:"result"`
+	AgentID string  `json:"agent_id,omitempty"` // агент, приславший результат
 }
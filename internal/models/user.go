@@ -1,7 +1,19 @@
 package models
 
+import "time"
+
+// Роли пользователей
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID       string `json:"id" db:"id"`
-	Login    string `json:"login" db:"login"`
-	Password string `json:"password" db:"password"`
+	ID        string    `json:"id" db:"id"`
+	Login     string    `json:"login" db:"login"`
+	Password  string    `json:"-" db:"password"`
+	Role      string    `json:"role" db:"role"`
+	Disabled  bool      `json:"disabled" db:"disabled"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordPHC(t *testing.T) {
+	hash, err := HashPassword("s3cret-pass")
+	if err != nil {
+		t.Fatalf("ошибка хеширования: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("хеш не в формате PHC: %s", hash)
+	}
+	if !CheckPassword(hash, "s3cret-pass") {
+		t.Error("верный пароль не прошел проверку")
+	}
+	if CheckPassword(hash, "wrong-pass") {
+		t.Error("неверный пароль прошел проверку")
+	}
+	if NeedsRehash(hash) {
+		t.Error("свежий хеш не должен требовать пересчета")
+	}
+
+	other, _ := HashPassword("s3cret-pass")
+	if other == hash {
+		t.Error("одинаковые пароли должны давать разные хеши из-за соли")
+	}
+}
+
+func TestLegacyHashes(t *testing.T) {
+	// Несоленый SHA-256, который хранился раньше
+	legacy := "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8" // "password"
+	if !CheckPassword(legacy, "password") {
+		t.Error("старый SHA-256 хеш должен проверяться")
+	}
+	if CheckPassword(legacy, "Password") {
+		t.Error("неверный пароль прошел проверку по SHA-256 хешу")
+	}
+	if !NeedsRehash(legacy) {
+		t.Error("SHA-256 хеш должен требовать пересчета")
+	}
+
+	bc, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if !CheckPassword(string(bc), "password") {
+		t.Error("bcrypt хеш должен проверяться")
+	}
+	if !NeedsRehash(string(bc)) {
+		t.Error("bcrypt хеш должен требовать пересчета")
+	}
+}
+
+func TestNeedsRehashOnParamsChange(t *testing.T) {
+	hash, _ := HashPassword("pass")
+	saved := PasswordParams
+	defer func() { PasswordParams = saved }()
+
+	PasswordParams.Iterations++
+	if !NeedsRehash(hash) {
+		t.Error("хеш со старыми параметрами должен требовать пересчета")
+	}
+	if !CheckPassword(hash, "pass") {
+		t.Error("хеш со старыми параметрами должен проверяться")
+	}
+	if CheckPassword("$argon2id$v=19$m=1,t=1$bad", "pass") {
+		t.Error("поврежденный хеш не должен проходить проверку")
+	}
+}
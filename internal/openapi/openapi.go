@@ -0,0 +1,83 @@
+// Package openapi — описание HTTP API оркестратора в формате OpenAPI 3
+// и локальная страница документации к нему
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// Spec — документ OpenAPI в JSON
+func Spec() []byte {
+	return spec
+}
+
+// SpecHandler отдает документ по GET /api/openapi.json
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// DocsHandler отдает страницу документации. Страница не тянет ничего
+// со сторонних адресов, документ берет с /api/openapi.json.
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// Document — разобранный документ, достаточный для проверки ответов
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+}
+
+// Operation — метод пути
+type Operation struct {
+	Summary   string               `json:"summary"`
+	Responses map[string]*Response `json:"responses"`
+}
+
+// Response — описание ответа; Ref ссылается на components.responses
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+// MediaType — схема тела для одного Content-Type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema — подмножество JSON Schema, которое используется в документе
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// Load разбирает встроенный документ
+func Load() (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(spec, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,180 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operation ищет метод пути; path — шаблон вида /api/v1/expressions/{id}
+func (d *Document) Operation(method, path string) (*Operation, bool) {
+	op, ok := d.Paths[path][strings.ToLower(method)]
+	return op, ok
+}
+
+// ValidateResponse проверяет, что ответ описан в документе: есть путь,
+// код ответа (или default), Content-Type, и JSON тело подходит под схему
+func (d *Document) ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	op, ok := d.Operation(method, path)
+	if !ok {
+		return fmt.Errorf("%s %s: нет в документе", method, path)
+	}
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s: код %d не описан", method, path, status)
+	}
+	if resp.Ref != "" {
+		if resp = d.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]; resp == nil {
+			return fmt.Errorf("%s %s: неизвестная ссылка на ответ", method, path)
+		}
+	}
+	if len(resp.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s %s %d: тело не описано: %s", method, path, status, body)
+		}
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s %s %d: Content-Type %q не описан", method, path, status, contentType)
+	}
+	if mediaType != "application/json" || media.Schema == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("%s %s %d: тело не JSON: %v", method, path, status, err)
+	}
+	if err := d.validate(media.Schema, v, "$"); err != nil {
+		return fmt.Errorf("%s %s %d: %w", method, path, status, err)
+	}
+	return nil
+}
+
+// validate сверяет значение со схемой; возвращает все несоответствия
+func (d *Document) validate(s *Schema, v interface{}, at string) error {
+	if s.Ref != "" {
+		ref := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if ref == nil {
+			return fmt.Errorf("%s: неизвестная схема %s", at, s.Ref)
+		}
+		return d.validate(ref, v, at)
+	}
+	if v == nil {
+		if s.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null", at)
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("%s: %v нет в enum %v", at, v, s.Enum)
+	}
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: ожидался объект", at)
+		}
+		return d.validateObject(s, m, at)
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: ожидался массив", at)
+		}
+		var errs []error
+		for i, item := range a {
+			if s.Items != nil {
+				errs = append(errs, d.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)))
+			}
+		}
+		return errors.Join(errs...)
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: ожидалась строка", at)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q не date-time", at, str)
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: ожидалось число", at)
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return fmt.Errorf("%s: %s не целое", at, n)
+			}
+		}
+		f, _ := n.Float64()
+		if s.Minimum != nil && f < *s.Minimum || s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%s: %s вне диапазона", at, n)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: ожидалось true или false", at)
+		}
+	}
+	return nil
+}
+
+// validateObject проверяет обязательные, описанные и лишние поля.
+// additionalProperties: false запрещает поля, которых нет в properties.
+func (d *Document) validateObject(s *Schema, m map[string]interface{}, at string) error {
+	var errs []error
+	for _, name := range s.Required {
+		if _, ok := m[name]; !ok {
+			errs = append(errs, fmt.Errorf("%s: нет обязательного поля %s", at, name))
+		}
+	}
+	var extra *Schema
+	closed := false
+	switch raw := bytes.TrimSpace(s.AdditionalProperties); {
+	case string(raw) == "false":
+		closed = true
+	case len(raw) > 0 && raw[0] == '{':
+		extra = &Schema{}
+		if err := json.Unmarshal(raw, extra); err != nil {
+			return fmt.Errorf("%s: неверный additionalProperties: %v", at, err)
+		}
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := at + "." + name
+		if prop, ok := s.Properties[name]; ok {
+			errs = append(errs, d.validate(prop, m[name], field))
+		} else if closed {
+			errs = append(errs, fmt.Errorf("%s: поле не описано", field))
+		} else if extra != nil {
+			errs = append(errs, d.validate(extra, m[name], field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMeAndChangeLogin(t *testing.T) {
+	r := newAuthRouter(t)
+	tokens := registerAndLogin(t, r, "erin", "erin's long password")
+
+	rr := doJSON(t, r, "GET", "/api/v1/me", tokens.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("профиль: код %d", rr.Code)
+	}
+	var me struct {
+		ID        string    `json:"id"`
+		Login     string    `json:"login"`
+		Password  string    `json:"password"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	decodeJSON(t, rr, &me)
+	if me.Login != "erin" || me.CreatedAt.IsZero() || me.Password != "" {
+		t.Errorf("неверный профиль: %s", rr.Body.String())
+	}
+
+	registerAndLogin(t, r, "frank", "frank's long password")
+	rr = doJSON(t, r, "PUT", "/api/v1/me/login", tokens.Token,
+		map[string]string{"login": "frank", "current_password": "erin's long password"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("занятый логин: код %d, ожидалось 409", rr.Code)
+	}
+	rr = doJSON(t, r, "PUT", "/api/v1/me/login", tokens.Token,
+		map[string]string{"login": "erin2", "current_password": "wrong"})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("неверный пароль: код %d, ожидалось 401", rr.Code)
+	}
+	rr = doJSON(t, r, "PUT", "/api/v1/me/login", tokens.Token,
+		map[string]string{"login": "erin2", "current_password": "erin's long password"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("смена логина: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "erin2", "password": "erin's long password"})
+	if rr.Code != http.StatusOK {
+		t.Errorf("вход с новым логином: код %d", rr.Code)
+	}
+}
+
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	r := newAuthRouter(t)
+	old := registerAndLogin(t, r, "grace", "grace's old password")
+
+	// Пароль меняется сразу после входа: старый токен обычно выдан в ту же секунду
+	rr := doJSON(t, r, "PUT", "/api/v1/me/password", old.Token,
+		map[string]string{"current_password": "grace's old password", "new_password": "grace's new password"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("смена пароля: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var fresh loginResponse
+	decodeJSON(t, rr, &fresh)
+
+	if rr := doJSON(t, r, "GET", "/api/v1/me", old.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("старый access токен: код %d, ожидалось 401", rr.Code)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": old.RefreshToken}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("старый refresh токен: код %d, ожидалось 401", rr.Code)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/me", fresh.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("новый access токен: код %d", rr.Code)
+	}
+	// Токены, выданные сразу после смены, тоже действительны
+	rr = doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": fresh.RefreshToken})
+	var refreshed loginResponse
+	decodeJSON(t, rr, &refreshed)
+	if rr := doJSON(t, r, "GET", "/api/v1/me", refreshed.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("access токен после refresh: код %d", rr.Code)
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "grace", "password": "grace's new password"})
+	var relogin loginResponse
+	decodeJSON(t, rr, &relogin)
+	if rr := doJSON(t, r, "GET", "/api/v1/me", relogin.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("access токен после входа: код %d", rr.Code)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "grace", "password": "grace's old password"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("вход со старым паролем: код %d, ожидалось 401", rr.Code)
+	}
+}
+
+func TestDeleteAccountPurgesData(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	tokens := registerAndLogin(t, r, "heidi", "heidi's long password")
+
+	var userID string
+	db.QueryRow("SELECT id FROM users WHERE login = 'heidi'").Scan(&userID)
+	if _, err := db.Exec("INSERT INTO expressions (id, expression, status, user_id) VALUES ('e1', '2+2', 'completed', ?)", userID); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doJSON(t, r, "DELETE", "/api/v1/me", tokens.Token, map[string]string{"current_password": "heidi's long password"})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("удаление: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	for _, table := range []string{"users", "expressions", "refresh_tokens"} {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		if n != 0 {
+			t.Errorf("в таблице %s осталось %d строк", table, n)
+		}
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/me", tokens.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("токен удаленного аккаунта: код %d, ожидалось 401", rr.Code)
+	}
+}
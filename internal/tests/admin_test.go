@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"calculator/internal/agentauth"
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+func TestAdminRoleRequired(t *testing.T) {
+	r, db, _ := newTestServer(t)
+
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	registerAndLogin(t, r, "root", "root's long password")
+	if err := api.EnsureAdmins(db, []string{"root"}); err != nil {
+		t.Fatal(err)
+	}
+	// Роль попадает в токен при входе
+	rr := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "root", "password": "root's long password"})
+	var root loginResponse
+	decodeJSON(t, rr, &root)
+
+	if rr := doJSON(t, r, "GET", "/api/v1/admin/users", user.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("обычный пользователь: код %d, ожидалось 403", rr.Code)
+	}
+	rr = doJSON(t, r, "GET", "/api/v1/admin/users", root.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("список пользователей: код %d", rr.Code)
+	}
+	var list struct {
+		Users []models.User `json:"users"`
+	}
+	decodeJSON(t, rr, &list)
+	if len(list.Users) != 2 {
+		t.Fatalf("ожидалось 2 пользователя, получено %d", len(list.Users))
+	}
+
+	var ivanID string
+	for _, u := range list.Users {
+		if u.Login == "ivan" {
+			ivanID = u.ID
+		}
+	}
+	rr = doJSON(t, r, "PATCH", "/api/v1/admin/users/"+ivanID, root.Token, map[string]bool{"disabled": true})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("блокировка: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/me", user.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("заблокированный пользователь: код %d, ожидалось 403", rr.Code)
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": user.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh заблокированного пользователя: код %d, ожидалось 401", rr.Code)
+	}
+}
+
+func TestAdminOperationCostsPersist(t *testing.T) {
+	r, db, _ := newTestServer(t)
+
+	registerAndLogin(t, r, "root", "root's long password")
+	api.EnsureAdmins(db, []string{"root"})
+	rr := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "root", "password": "root's long password"})
+	var root loginResponse
+	decodeJSON(t, rr, &root)
+
+	rr = doJSON(t, r, "PUT", "/api/v1/admin/operation-costs", root.Token, map[string]int64{"%": 10})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("неизвестная операция: код %d, ожидалось 400", rr.Code)
+	}
+	rr = doJSON(t, r, "PUT", "/api/v1/admin/operation-costs", root.Token, map[string]int64{"*": 50})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("изменение стоимости: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+
+	// Новый Handler (перезапуск оркестратора) подхватывает сохраненное значение
+	r2 := api.NewRouter(db, api.NewHandler(db), agentauth.Dev())
+	var costs struct {
+		OperationCosts map[string]int64 `json:"operation_costs"`
+	}
+	decodeJSON(t, doJSON(t, r2, "GET", "/api/v1/admin/operation-costs", root.Token, nil), &costs)
+	if costs.OperationCosts["*"] != 50 || costs.OperationCosts["+"] != api.DefaultOperationTimes["+"] {
+		t.Errorf("неверные стоимости после перезапуска: %v", costs.OperationCosts)
+	}
+}
+
+func TestAdminListExpressions(t *testing.T) {
+	r, db, _ := newTestServer(t)
+
+	ivan := registerAndLogin(t, r, "ivan", "ivan's long password")
+	petr := registerAndLogin(t, r, "petr", "petr's long password")
+	registerAndLogin(t, r, "root", "root's long password")
+	api.EnsureAdmins(db, []string{"root"})
+	var root loginResponse
+	decodeJSON(t, doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "root", "password": "root's long password"}), &root)
+	for _, user := range []loginResponse{ivan, petr, ivan} {
+		submitExpression(t, r, user.Token, "1+1")
+	}
+
+	// Все выражения по две на странице, без повторов
+	type page struct {
+		Expressions []models.Expression `json:"expressions"`
+		NextCursor  string              `json:"next_cursor"`
+		Total       int                 `json:"total"`
+	}
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		rr := doJSON(t, r, "GET", "/api/v1/admin/expressions?limit=2&cursor="+cursor, root.Token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("страница %d: код %d, тело %s", pages, rr.Code, rr.Body.String())
+		}
+		var p page
+		decodeJSON(t, rr, &p)
+		if p.Total != 3 || len(p.Expressions) > 2 {
+			t.Fatalf("страница %d: %+v", pages, p)
+		}
+		for _, e := range p.Expressions {
+			if seen[e.ID] {
+				t.Errorf("повтор %s", e.ID)
+			}
+			seen[e.ID] = true
+		}
+		if cursor = p.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("получено выражений: %d", len(seen))
+	}
+
+	var p page
+	var ivanID string
+	db.QueryRow("SELECT id FROM users WHERE login = 'ivan'").Scan(&ivanID)
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/admin/expressions?user_id="+ivanID, root.Token, nil), &p)
+	if p.Total != 2 || len(p.Expressions) != 2 {
+		t.Errorf("выражения одного пользователя: %+v", p)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/admin/expressions?limit=0", root.Token, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: код %d, ожидалось 400", rr.Code)
+	}
+
+	// Ошибка БД — 500, а не нули в ответе
+	rr := doJSON(t, r, "GET", "/api/v1/admin/queue", root.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("очередь: код %d", rr.Code)
+	}
+	if _, err := db.Exec("DROP TABLE tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/admin/queue", root.Token, nil); rr.Code != http.StatusInternalServerError {
+		t.Errorf("очередь без таблицы задач: код %d, ожидалось 500", rr.Code)
+	}
+}
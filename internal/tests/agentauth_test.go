@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"calculator/calculatorpb"
+	"calculator/internal"
+	"calculator/internal/agentauth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startAuthAgentServer запускает AgentService с проверкой токенов агентов.
+// В seen записываются имена агентов, получивших задачу.
+func startAuthAgentServer(t *testing.T, tokens *agentauth.Tokens, seen *[]string) func(opts ...grpc.DialOption) calculatorpb.AgentServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(bufSize)
+	server := grpc.NewServer(grpc.UnaryInterceptor(tokens.UnaryServerInterceptor()))
+	calculatorpb.RegisterAgentServiceServer(server, &internal.AgentServiceServerImpl{
+		TaskProvider: func(agentID string) (*calculatorpb.Task, bool) {
+			*seen = append(*seen, agentID)
+			return &calculatorpb.Task{Id: 1, Arg1: "1", Arg2: "2", Operation: "+"}, true
+		},
+		ResultHandler: func(agentID string, taskID int64, result float64) error { return nil },
+	})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return func(opts ...grpc.DialOption) calculatorpb.AgentServiceClient {
+		opts = append(opts,
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.DialContext(context.Background(), "bufnet", opts...)
+		if err != nil {
+			t.Fatalf("Не удалось создать соединение: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return calculatorpb.NewAgentServiceClient(conn)
+	}
+}
+
+func TestAgentGRPCAuth(t *testing.T) {
+	tokens, err := agentauth.NewTokens(map[string]string{"agent-1": "first agent secret", "agent-2": "second agent secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	dial := startAuthAgentServer(t, tokens, &seen)
+	ctx := context.Background()
+
+	for name, client := range map[string]calculatorpb.AgentServiceClient{
+		"без токена":  dial(),
+		"чужой токен": dial(grpc.WithPerRPCCredentials(agentauth.Credentials("guessed", false))),
+	} {
+		_, err := client.GetTask(ctx, &calculatorpb.GetTaskRequest{})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: GetTask вернул %v, ожидалось Unauthenticated", name, err)
+		}
+		_, err = client.SendResult(ctx, &calculatorpb.SendResultRequest{TaskId: 1, Result: 3})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: SendResult вернул %v, ожидалось Unauthenticated", name, err)
+		}
+	}
+	if len(seen) != 0 {
+		t.Fatalf("задачи выданы неаутентифицированным агентам: %v", seen)
+	}
+
+	client := dial(grpc.WithPerRPCCredentials(agentauth.Credentials("second agent secret", false)))
+	resp, err := client.GetTask(ctx, &calculatorpb.GetTaskRequest{})
+	if err != nil || !resp.HasTask {
+		t.Fatalf("GetTask с токеном: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != "agent-2" {
+		t.Errorf("задача должна быть записана на agent-2, записано %v", seen)
+	}
+}
+
+func TestAgentHTTPAuth(t *testing.T) {
+	tokens, _ := agentauth.NewTokens(map[string]string{"agent-1": "first agent secret"})
+	var got string
+	h := tokens.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = agentauth.Identity(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/internal/task", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("без токена: код %d, ожидалось 401", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/internal/task", nil)
+	req.Header.Set(agentauth.HeaderName, "first agent secret")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || got != "agent-1" {
+		t.Errorf("с токеном: код %d, агент %q", rr.Code, got)
+	}
+}
+
+func TestAgentTokensLoad(t *testing.T) {
+	if _, err := agentauth.Load(agentauth.Config{Production: true}); err == nil {
+		t.Error("в production без токенов агентов запуск должен быть запрещен")
+	}
+	if _, err := agentauth.Load(agentauth.Config{Production: true, Tokens: "a=short"}); err == nil {
+		t.Error("в production короткий токен должен отклоняться")
+	}
+	tokens, err := agentauth.Load(agentauth.Config{Tokens: "a=token-a, b=token-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := tokens.Identify("token-b"); !ok || name != "b" {
+		t.Errorf("token-b: %q %v", name, ok)
+	}
+	if _, ok := tokens.Identify(""); ok {
+		t.Error("пустой токен не должен приниматься")
+	}
+}
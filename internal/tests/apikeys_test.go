@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+)
+
+type apiKeyResponse struct {
+	ID         string   `json:"id"`
+	Key        string   `json:"key"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	LastUsedAt *string  `json:"last_used_at"`
+	RevokedAt  *string  `json:"revoked_at"`
+}
+
+// doWithAPIKey выполняет запрос без тела с ключом в заголовке header
+func doWithAPIKey(r http.Handler, method, path, header, value string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(header, value)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, map[string]interface{}{
+		"name": "ci", "scopes": []string{"read"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("создание ключа: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var key apiKeyResponse
+	decodeJSON(t, rr, &key)
+	if key.Key == "" {
+		t.Fatal("ключ должен возвращаться при создании")
+	}
+
+	if code := doWithAPIKey(r, "GET", "/api/v1/expressions", "X-API-Key", key.Key); code != http.StatusOK {
+		t.Errorf("X-API-Key: код %d", code)
+	}
+	if code := doWithAPIKey(r, "GET", "/api/v1/expressions", "Authorization", "ApiKey "+key.Key); code != http.StatusOK {
+		t.Errorf("Authorization: ApiKey: код %d", code)
+	}
+	if code := doWithAPIKey(r, "POST", "/api/v1/calculate", "X-API-Key", key.Key); code != http.StatusForbidden {
+		t.Errorf("ключ без scope calculate: код %d, ожидалось 403", code)
+	}
+	if code := doWithAPIKey(r, "GET", "/api/v1/api-keys", "X-API-Key", key.Key); code != http.StatusForbidden {
+		t.Errorf("управление ключами по API ключу: код %d, ожидалось 403", code)
+	}
+	if code := doWithAPIKey(r, "GET", "/api/v1/expressions", "X-API-Key", key.Key+"x"); code != http.StatusUnauthorized {
+		t.Errorf("неверный ключ: код %d, ожидалось 401", code)
+	}
+
+	// В списке нет самого ключа, но видно время последнего использования
+	rr = doJSON(t, r, "GET", "/api/v1/api-keys", user.Token, nil)
+	var list struct {
+		APIKeys []apiKeyResponse `json:"api_keys"`
+	}
+	decodeJSON(t, rr, &list)
+	if len(list.APIKeys) != 1 || list.APIKeys[0].Key != "" || list.APIKeys[0].LastUsedAt == nil {
+		t.Fatalf("неверный список ключей: %s", rr.Body.String())
+	}
+}
+
+func TestAPIKeyRevoke(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+
+	rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, map[string]string{"name": "script"})
+	var key apiKeyResponse
+	decodeJSON(t, rr, &key)
+	if len(key.Scopes) != 2 {
+		t.Errorf("по умолчанию ожидались обе области доступа, получено %v", key.Scopes)
+	}
+
+	if rr := doJSON(t, r, "DELETE", "/api/v1/api-keys/"+key.ID, other.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("отзыв чужого ключа: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "DELETE", "/api/v1/api-keys/"+key.ID, user.Token, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("отзыв ключа: код %d", rr.Code)
+	}
+	if code := doWithAPIKey(r, "GET", "/api/v1/expressions", "X-API-Key", key.Key); code != http.StatusUnauthorized {
+		t.Errorf("отозванный ключ: код %d, ожидалось 401", code)
+	}
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	cases := []map[string]interface{}{
+		{"name": ""},
+		{"name": "x", "scopes": []string{"admin"}},
+		{"name": "x", "expires_in": "-1h"},
+	}
+	for _, body := range cases {
+		if rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%v: код %d, ожидалось 400", body, rr.Code)
+		}
+	}
+}
+
+func TestAPIKeyLimitSkipsExpired(t *testing.T) {
+	saved := api.MaxAPIKeysPerUser
+	defer func() { api.MaxAPIKeysPerUser = saved }()
+	api.MaxAPIKeysPerUser = 1
+
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	if rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, map[string]string{"name": "old", "expires_in": "1ms"}); rr.Code != http.StatusCreated {
+		t.Fatalf("создание ключа: код %d", rr.Code)
+	}
+	time.Sleep(10 * time.Millisecond)
+	// Истекший ключ не занимает место в лимите
+	if rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, map[string]string{"name": "new"}); rr.Code != http.StatusCreated {
+		t.Fatalf("ключ взамен истекшего: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/api-keys", user.Token, map[string]string{"name": "extra"}); rr.Code != http.StatusConflict {
+		t.Errorf("сверх лимита: код %d, ожидалось 409", rr.Code)
+	}
+}
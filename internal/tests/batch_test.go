@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+type batchResponse struct {
+	BatchID string          `json:"batch_id"`
+	Items   []api.BatchItem `json:"items"`
+}
+
+type batchProgress struct {
+	Batch api.BatchProgress `json:"batch"`
+}
+
+func TestCalculateBatch(t *testing.T) {
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+
+	rr := doJSON(t, r, "POST", "/api/v1/calculate/batch", user.Token, map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"expression": "(a+b)*c", "variables": map[string]float64{"a": 1, "b": 2, "c": 4}, "ref": "first"},
+			{"expression": "2*(3", "ref": "broken"},
+			{"expression": "x", "variables": map[string]float64{"x": 7}},
+			{"expression": "y+1", "ref": "unbound"},
+		},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("пакет: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var batch batchResponse
+	decodeJSON(t, rr, &batch)
+	if batch.BatchID == "" || len(batch.Items) != 4 {
+		t.Fatalf("ответ пакета: %+v", batch)
+	}
+	items := batch.Items
+	if items[0].ID == "" || items[0].Ref != "first" || items[2].ID == "" {
+		t.Errorf("корректные элементы: %+v %+v", items[0], items[2])
+	}
+	if items[1].ID != "" || items[1].Error == nil || items[1].Error.Code != "unbalanced_parenthesis" || items[1].Error.Details["position"] != 2.0 {
+		t.Errorf("незакрытая скобка: %+v", items[1])
+	}
+	if items[3].Error == nil || items[3].Error.Code != "unknown_variable" || items[3].Ref != "unbound" {
+		t.Errorf("неизвестная переменная: %+v", items[3])
+	}
+
+	// Выражение без операций готово сразу
+	var got struct {
+		Expression models.Expression `json:"expression"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+items[2].ID, user.Token, nil), &got)
+	if got.Expression.Status != models.StatusCompleted || got.Expression.Result == nil || *got.Expression.Result != 7 ||
+		got.Expression.BatchID != batch.BatchID {
+		t.Errorf("выражение без операций: %+v", got.Expression)
+	}
+
+	// Умножение ждет результата сложения
+	sum, ok := h.GetTaskForAgent("agent-1")
+	if !ok || sum.Arg1 != "1" || sum.Arg2 != "2" {
+		t.Fatalf("первая задача: %+v", sum)
+	}
+	if _, ok := h.GetTaskForAgent("agent-1"); ok {
+		t.Fatal("выдана задача с неизвестным аргументом")
+	}
+	if err := h.SubmitAgentResult("agent-1", sum.Id, 3); err != nil {
+		t.Fatal(err)
+	}
+	var progress batchProgress
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/batches/"+batch.BatchID, user.Token, nil), &progress)
+	p := progress.Batch
+	if p.ItemCount != 2 || p.TaskCount != 2 || p.TasksDone != 1 || p.Finished ||
+		p.Statuses[models.StatusProcessing] != 1 || p.Statuses[models.StatusCompleted] != 1 {
+		t.Errorf("ход пакета: %+v", p)
+	}
+
+	product, ok := h.GetTaskForAgent("agent-1")
+	if !ok || product.Arg1 != "3" || product.Arg2 != "4" {
+		t.Fatalf("зависимая задача: %+v", product)
+	}
+	if err := h.SubmitAgentResult("agent-1", product.Id, 12); err != nil {
+		t.Fatal(err)
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+items[0].ID, user.Token, nil), &got)
+	if got.Expression.Result == nil || *got.Expression.Result != 12 || got.Expression.Variables["c"] != 4 || got.Expression.Ref != "first" {
+		t.Errorf("результат: %+v", got.Expression)
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/batches/"+batch.BatchID, user.Token, nil), &progress)
+	if !progress.Batch.Finished || progress.Batch.Statuses[models.StatusCompleted] != 2 {
+		t.Errorf("завершенный пакет: %+v", progress.Batch)
+	}
+
+	if rr := doJSON(t, r, "GET", "/api/v1/batches/"+batch.BatchID, other.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("чужой пакет: код %d, ожидалось 404", rr.Code)
+	}
+}
+
+func TestCalculateBatchValidation(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	if rr := doJSON(t, r, "POST", "/api/v1/calculate/batch", user.Token, map[string]interface{}{"items": []interface{}{}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("пустой пакет: код %d, ожидалось 400", rr.Code)
+	}
+	tooMany := make([]map[string]string, api.BatchMaxItems+1)
+	for i := range tooMany {
+		tooMany[i] = map[string]string{"expression": strconv.Itoa(i) + "+1"}
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/calculate/batch", user.Token, map[string]interface{}{"items": tooMany}); rr.Code != http.StatusBadRequest {
+		t.Errorf("слишком большой пакет: код %d, ожидалось 400", rr.Code)
+	}
+
+	// Ни одного корректного выражения — ничего не сохраняется
+	rr := doJSON(t, r, "POST", "/api/v1/calculate/batch", user.Token, map[string]interface{}{
+		"items": []map[string]interface{}{{"expression": "2+"}, {"expression": "1", "variables": map[string]float64{"1x": 1}}},
+	})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("некорректный пакет: код %d", rr.Code)
+	}
+	var failed struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Items []api.BatchItem `json:"items"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	decodeJSON(t, rr, &failed)
+	items := failed.Error.Details.Items
+	if failed.Error.Code != "invalid_expressions" || len(items) != 2 ||
+		items[0].Error.Code != "unexpected_end" || items[1].Error.Code != "invalid_variable" {
+		t.Errorf("ошибки пакета: %+v", failed)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&n)
+	if n != 0 {
+		t.Errorf("сохранено %d выражений", n)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"calculator/internal/apierror"
+)
+
+type errorEnvelope struct {
+	Error struct {
+		Code      string                 `json:"code"`
+		Message   string                 `json:"message"`
+		Details   map[string]interface{} `json:"details"`
+		RequestID string                 `json:"request_id"`
+	} `json:"error"`
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	srv := apierror.Middleware(r)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	get := func(path, token, lang, requestID string) (*httptest.ResponseRecorder, errorEnvelope) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		if requestID != "" {
+			req.Header.Set(apierror.RequestIDHeader, requestID)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: Content-Type %q", path, ct)
+		}
+		var env errorEnvelope
+		decodeJSON(t, rr, &env)
+		return rr, env
+	}
+
+	// По умолчанию сообщение на русском, request_id выдается сервером
+	rr, env := get("/api/v1/expressions/missing", user.Token, "", "")
+	if rr.Code != http.StatusNotFound || env.Error.Code != "expression_not_found" || env.Error.Message != "Выражение не найдено" {
+		t.Errorf("не найдено: код %d, %+v", rr.Code, env)
+	}
+	if env.Error.RequestID == "" || rr.Header().Get(apierror.RequestIDHeader) != env.Error.RequestID {
+		t.Errorf("request_id %q, заголовок %q", env.Error.RequestID, rr.Header().Get(apierror.RequestIDHeader))
+	}
+
+	// Язык выбирается по q, id клиента возвращается как есть
+	rr, env = get("/api/v1/expressions/missing", "", "ru;q=0.5, en-US;q=0.9", "trace-42")
+	if rr.Code != http.StatusUnauthorized || env.Error.Code != "unauthorized" || env.Error.Message != "Authentication required" ||
+		env.Error.RequestID != "trace-42" {
+		t.Errorf("без токена: код %d, %+v", rr.Code, env)
+	}
+
+	// Неизвестный язык — русский
+	if _, env = get("/api/v1/expressions/missing", "", "de", ""); env.Error.Message != "Требуется авторизация" {
+		t.Errorf("неизвестный язык: %+v", env)
+	}
+
+	// Подробности политики паролей — в details
+	req := httptest.NewRequest("POST", "/api/v1/register", strings.NewReader(`{"login":"petr","password":"short"}`))
+	req.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	decodeJSON(t, rec, &env)
+	if rec.Code != http.StatusBadRequest || env.Error.Code != "password_too_short" || env.Error.Details["min_length"] == nil {
+		t.Errorf("короткий пароль: код %d, %+v", rec.Code, env)
+	}
+}
+
+// Неизвестный путь и чужой метод отвечают тем же форматом, а не
+// текстом по умолчанию из gorilla/mux
+func TestRouterErrors(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	r.NotFoundHandler = http.HandlerFunc(apierror.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(apierror.MethodNotAllowedHandler)
+	srv := apierror.Middleware(r)
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		code         string
+		message      string
+	}{
+		{"GET", "/api/v1/nothing-here", http.StatusNotFound, "not_found", "Resource not found"},
+		{"DELETE", "/api/v1/expressions/1", http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Accept-Language", "en")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s %s: Content-Type %q", tc.method, tc.path, ct)
+		}
+		var env errorEnvelope
+		decodeJSON(t, rr, &env)
+		if rr.Code != tc.status || env.Error.Code != tc.code || env.Error.Message != tc.message || env.Error.RequestID == "" {
+			t.Errorf("%s %s: код %d, %+v", tc.method, tc.path, rr.Code, env)
+		}
+	}
+}
+
+func TestErrorCatalogue(t *testing.T) {
+	for _, code := range apierror.Codes() {
+		if apierror.Status(code) < 400 {
+			t.Errorf("%s: статус %d", code, apierror.Status(code))
+		}
+		if apierror.Message(code, "ru") == "" || apierror.Message(code, "en") == "" || apierror.Message(code, "ru") == apierror.Message(code, "en") {
+			t.Errorf("%s: нет перевода", code)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type evaluateResponse struct {
+	ID     string   `json:"id"`
+	Result *float64 `json:"result"`
+	Status string   `json:"status"`
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details struct {
+			ID     string `json:"id"`
+			Reason string `json:"reason"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func TestEvaluateLocal(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	var resp evaluateResponse
+	rr := doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]interface{}{
+		"expression": "(a+2)*2", "variables": map[string]float64{"a": 1},
+	})
+	decodeJSON(t, rr, &resp)
+	if rr.Code != http.StatusOK || resp.Result == nil || *resp.Result != 6 || resp.ID != "" {
+		t.Errorf("локальное вычисление: код %d, %+v", rr.Code, resp)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]string{"expression": "2*"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("ошибка разбора: код %d, ожидалось 422", rr.Code)
+	}
+	if _, total := listAll(t, r, user.Token, nil); total != 0 {
+		t.Errorf("без persist сохранено %d выражений", total)
+	}
+
+	// С persist выражение попадает в историю, в том числе с ошибкой
+	resp = evaluateResponse{}
+	rr = doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]interface{}{"expression": "1/(2-2)", "persist": true})
+	decodeJSON(t, rr, &resp)
+	if rr.Code != http.StatusUnprocessableEntity || resp.Error == nil ||
+		resp.Error.Code != "evaluation_failed" || resp.Error.Details.ID == "" || resp.Error.Details.Reason != "division by zero" {
+		t.Errorf("деление на ноль: код %d, %+v", rr.Code, resp)
+	}
+	var page expressionsPage
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions", user.Token, nil), &page)
+	if page.Total != 1 || page.Expressions[0].Status != "failed" || page.Expressions[0].TaskCount != 0 {
+		t.Errorf("сохраненное выражение: %+v", page.Expressions)
+	}
+}
+
+func TestEvaluateDistributed(t *testing.T) {
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			if task, ok := h.GetTaskForAgent("agent-1"); ok {
+				h.SubmitAgentResult("agent-1", task.Id, 5)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	var resp evaluateResponse
+	rr := doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]string{"expression": "2+3", "mode": "distributed", "timeout": "5s"})
+	decodeJSON(t, rr, &resp)
+	if rr.Code != http.StatusOK || resp.Result == nil || *resp.Result != 5 || resp.ID == "" {
+		t.Errorf("распределенное вычисление: код %d, %+v", rr.Code, resp)
+	}
+
+	// Агентов нет — по истечении timeout 202 и id для опроса
+	resp = evaluateResponse{}
+	rr = doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]string{"expression": "2+3", "mode": "distributed", "timeout": "50ms"})
+	decodeJSON(t, rr, &resp)
+	if rr.Code != http.StatusAccepted || resp.ID == "" || resp.Status != "pending" {
+		t.Errorf("истек timeout: код %d, %+v", rr.Code, resp)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/evaluate", user.Token, map[string]string{"expression": "2+3", "mode": "distributed", "timeout": "1h"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("timeout больше предела: код %d", rr.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+type sseEvent struct {
+	id, typ string
+	expr    models.Expression
+}
+
+// openEvents подключается к потоку событий и возвращает канал
+// разобранных событий и пульсов (typ == "heartbeat")
+func openEvents(t *testing.T, baseURL, token, lastID string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest("GET", baseURL+"/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("поток событий: код %d, тип %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		sc := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, ": heartbeat"):
+				out <- sseEvent{typ: "heartbeat"}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.expr)
+			case line == "" && e.typ != "":
+				out <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent, skipHeartbeats bool) sseEvent {
+	t.Helper()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("поток событий закрыт")
+			}
+			if skipHeartbeats && e.typ == "heartbeat" {
+				continue
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("нет события")
+		}
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	oldHeartbeat := api.HeartbeatInterval
+	api.HeartbeatInterval = 100 * time.Millisecond
+	t.Cleanup(func() { api.HeartbeatInterval = oldHeartbeat })
+
+	r, _, h := newTestServer(t)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+	events := openEvents(t, srv.URL, user.Token, "")
+	otherEvents := openEvents(t, srv.URL, other.Token, "")
+
+	if e := nextEvent(t, events, false); e.typ != "heartbeat" {
+		t.Fatalf("ожидался пульс, получено %s", e.typ)
+	}
+
+	id := submitExpression(t, r, user.Token, "2+3")
+	created := nextEvent(t, events, true)
+	if created.typ != api.EventExpressionCreated || created.expr.ID != id || created.expr.TaskCount != 1 {
+		t.Fatalf("ожидалось expression.created: %+v", created)
+	}
+	task, _ := h.GetTaskForAgent("agent-1")
+	if err := h.SubmitAgentResult("agent-1", task.Id, 5); err != nil {
+		t.Fatal(err)
+	}
+	progress := nextEvent(t, events, true)
+	if progress.typ != api.EventExpressionProgress || progress.expr.TasksDone != 1 {
+		t.Errorf("ожидалось expression.progress: %+v", progress)
+	}
+	completed := nextEvent(t, events, true)
+	if completed.typ != api.EventExpressionCompleted || completed.expr.Result == nil || *completed.expr.Result != 5 {
+		t.Errorf("ожидалось expression.completed: %+v", completed)
+	}
+
+	// Деление на ноль завершает выражение с ошибкой
+	id = submitExpression(t, r, user.Token, "1/0")
+	nextEvent(t, events, true)
+	task, _ = h.GetTaskForAgent("agent-1")
+	if err := h.SubmitAgentResult("agent-1", task.Id, math.Inf(1)); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, true)
+	failed := nextEvent(t, events, true)
+	if failed.typ != api.EventExpressionFailed || failed.expr.ID != id || failed.expr.Status != models.StatusFailed || failed.expr.Error != "division by zero" {
+		t.Errorf("ожидалось expression.failed: %+v", failed)
+	}
+
+	// Возобновление с Last-Event-ID отдает пропущенные события из буфера
+	resumed := openEvents(t, srv.URL, user.Token, created.id)
+	for _, want := range []string{api.EventExpressionProgress, api.EventExpressionCompleted, api.EventExpressionCreated} {
+		if e := nextEvent(t, resumed, true); e.typ != want {
+			t.Errorf("повтор: ожидалось %s, получено %s", want, e.typ)
+		}
+	}
+
+	// ID от прошлого запуска сервера больше выданных: повторяется весь буфер
+	restarted := openEvents(t, srv.URL, user.Token, "1000000")
+	if e := nextEvent(t, restarted, true); e.typ != api.EventExpressionCreated || e.id != created.id {
+		t.Errorf("повтор после перезапуска: ожидалось %s %s, получено %s %s", api.EventExpressionCreated, created.id, e.typ, e.id)
+	}
+
+	// Чужие события не приходят
+	if e := nextEvent(t, otherEvents, false); e.typ != "heartbeat" {
+		t.Errorf("событие чужого пользователя: %+v", e)
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"calculator/internal/models"
+)
+
+type expressionsPage struct {
+	Expressions []models.Expression `json:"expressions"`
+	NextCursor  string              `json:"next_cursor"`
+	Total       int                 `json:"total"`
+}
+
+// listAll проходит все страницы и возвращает id в порядке выдачи
+func listAll(t *testing.T, r http.Handler, token string, params url.Values) ([]string, int) {
+	t.Helper()
+	var ids []string
+	total := -1
+	for page := 0; page < 20; page++ {
+		rr := doJSON(t, r, "GET", "/api/v1/expressions?"+params.Encode(), token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("список: код %d, тело %s", rr.Code, rr.Body.String())
+		}
+		var p expressionsPage
+		decodeJSON(t, rr, &p)
+		total = p.Total
+		for _, e := range p.Expressions {
+			ids = append(ids, e.ID)
+		}
+		if p.NextCursor == "" {
+			return ids, total
+		}
+		params.Set("cursor", p.NextCursor)
+	}
+	t.Fatal("слишком много страниц")
+	return nil, 0
+}
+
+func TestExpressionsPagination(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	complete := func(id string, result float64) {
+		if _, err := db.Exec("UPDATE expressions SET status = ?, result = ? WHERE id = ?", models.StatusCompleted, result, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+	submitExpression(t, r, other.Token, "1+1")
+
+	var ids []string
+	for _, e := range []string{"1+1", "2*3", "50*x_1", "4-1", "9/3"} {
+		ids = append(ids, submitCalculation(t, r, user.Token, map[string]interface{}{
+			"expression": e, "variables": map[string]float64{"x_1": 2},
+		}))
+	}
+
+	got, total := listAll(t, r, user.Token, url.Values{"limit": {"2"}})
+	if total != 5 || len(got) != 5 {
+		t.Fatalf("ожидалось 5 выражений, total=%d, получено %d", total, len(got))
+	}
+	// По умолчанию новые первыми
+	for i := range ids {
+		if got[i] != ids[len(ids)-1-i] {
+			t.Fatalf("неверный порядок: %v, ожидалось обратное к %v", got, ids)
+		}
+	}
+
+	// Подстрока ищется буквально, % и _ не являются шаблонами
+	got, total = listAll(t, r, user.Token, url.Values{"q": {"_"}})
+	if total != 1 || len(got) != 1 || got[0] != ids[2] {
+		t.Errorf("поиск по подстроке: total=%d, %v", total, got)
+	}
+	if _, total = listAll(t, r, user.Token, url.Values{"q": {"%"}}); total != 0 {
+		t.Errorf("поиск %%: total=%d, ожидалось 0", total)
+	}
+
+	complete(ids[0], 10)
+	complete(ids[1], 6)
+	complete(ids[3], 3)
+	got, total = listAll(t, r, user.Token, url.Values{"status": {"completed"}, "sort": {"result"}, "order": {"asc"}, "limit": {"1"}})
+	if total != 3 || len(got) != 3 || got[0] != ids[3] || got[1] != ids[1] || got[2] != ids[0] {
+		t.Errorf("сортировка по результату: total=%d, %v", total, got)
+	}
+	// Выражения без результата идут в конце
+	got, _ = listAll(t, r, user.Token, url.Values{"sort": {"result"}, "order": {"desc"}, "limit": {"2"}})
+	if len(got) != 5 || got[0] != ids[0] || got[2] != ids[3] {
+		t.Errorf("сортировка по убыванию результата: %v", got)
+	}
+}
+
+func TestExpressionsListValidation(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	for _, q := range []string{"limit=0", "limit=100000", "sort=id", "order=up", "status=done", "created_after=yesterday", "cursor=!!!"} {
+		if rr := doJSON(t, r, "GET", "/api/v1/expressions?"+q, user.Token, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: код %d, ожидалось 400", q, rr.Code)
+		}
+	}
+	// Курсор от другой сортировки не подходит
+	submitExpression(t, r, user.Token, "1+1")
+	submitExpression(t, r, user.Token, "1+2")
+	var p expressionsPage
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions?limit=1", user.Token, nil), &p)
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions?sort=result&cursor="+p.NextCursor, user.Token, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("курсор другой сортировки: код %d, ожидалось 400", rr.Code)
+	}
+}
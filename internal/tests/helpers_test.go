@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"calculator/internal"
+	"calculator/internal/agentauth"
+	"calculator/internal/api"
+	"calculator/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// testAgentToken — токен агента "agent-1" для /internal
+const testAgentToken = "test-agent-token"
+
+// newTestDB открывает временную SQLite базу с актуальной схемой
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := internal.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Ошибка открытия БД: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("Ошибка миграции БД: %v", err)
+	}
+	return db
+}
+
+// newTestServer собирает маршруты оркестратора через api.NewRouter на
+// чистой БД: тесты проходят те же middleware и порядок маршрутов, что и сервер
+func newTestServer(t *testing.T) (*mux.Router, *sql.DB, *api.Handler) {
+	t.Helper()
+	db := newTestDB(t)
+	tokens, err := agentauth.NewTokens(map[string]string{"agent-1": testAgentToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := api.NewHandler(db)
+	return api.NewRouter(db, h, tokens), db, h
+}
+
+// doJSON выполняет запрос к роутеру и возвращает записанный ответ
+func doJSON(t *testing.T, h http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// decodeJSON разбирает тело ответа
+func decodeJSON(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+		t.Fatalf("Не удалось разобрать JSON ответ: %v. Тело ответа: %s", err, rr.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+)
+
+func calculateWithKey(t *testing.T, r http.Handler, token, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.IdempotencyKeyHeader, key)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+
+	first := calculateWithKey(t, r, user.Token, "order-1", `{"expression": "2+3"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("первый запрос: код %d, тело %s", first.Code, first.Body.String())
+	}
+	// Повтор с тем же телом, иначе отформатированным, — тот же ответ
+	retry := calculateWithKey(t, r, user.Token, "order-1", `{ "expression":"2+3" }`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get(api.IdempotentReplayedHeader) != "true" {
+		t.Errorf("повтор: код %d, тело %s, ожидалось %s", retry.Code, retry.Body.String(), first.Body.String())
+	}
+	if _, ok := h.GetTaskForAgent("agent-1"); !ok {
+		t.Fatal("нет задачи первого запроса")
+	}
+	if _, ok := h.GetTaskForAgent("agent-1"); ok {
+		t.Error("повтор поставил задачи в очередь еще раз")
+	}
+
+	if rr := calculateWithKey(t, r, user.Token, "order-1", `{"expression": "2+4"}`); rr.Code != http.StatusConflict {
+		t.Errorf("другое тело: код %d, ожидалось 409", rr.Code)
+	}
+	// Ключи у каждого пользователя свои
+	if rr := calculateWithKey(t, r, other.Token, "order-1", `{"expression": "2+4"}`); rr.Code != http.StatusCreated {
+		t.Errorf("ключ другого пользователя: код %d", rr.Code)
+	}
+
+	// Неразобранное выражение ключ не занимает
+	if rr := calculateWithKey(t, r, user.Token, "order-2", `{"expression": "2+"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("ошибка разбора: код %d", rr.Code)
+	}
+	if rr := calculateWithKey(t, r, user.Token, "order-2", `{"expression": "2+5"}`); rr.Code != http.StatusCreated {
+		t.Errorf("исправленный запрос: код %d", rr.Code)
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	old := api.IdempotencyTTL
+	api.IdempotencyTTL = -time.Second
+	t.Cleanup(func() { api.IdempotencyTTL = old })
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	first := calculateWithKey(t, r, user.Token, "order-1", `{"expression": "2+3"}`)
+	second := calculateWithKey(t, r, user.Token, "order-1", `{"expression": "2+4"}`)
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated || first.Body.String() == second.Body.String() {
+		t.Errorf("истекший ключ: %d %s, %d %s", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+}
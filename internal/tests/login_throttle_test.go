@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+)
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	r := newAuthRouter(t)
+	testCases := []struct {
+		name     string
+		password string
+		want     int
+	}{
+		{"TooShort", "abc12", http.StatusBadRequest},
+		{"Common", "Password123", http.StatusBadRequest},
+		{"SameAsLogin", "policyuser", http.StatusBadRequest},
+		{"TooLong", string(make([]byte, 200)), http.StatusBadRequest},
+		{"Good", "purple monkey dishwasher", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doJSON(t, r, "POST", "/api/v1/register", "", map[string]string{"login": "policyuser", "password": tc.password})
+			if rr.Code != tc.want {
+				t.Errorf("код %d, ожидалось %d, тело %s", rr.Code, tc.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	saved := api.LoginThrottle
+	defer func() { api.LoginThrottle = saved }()
+	api.LoginThrottle.MaxFailures = 3
+	api.LoginThrottle.BaseLockout = time.Minute
+
+	r := newAuthRouter(t)
+	registerAndLogin(t, r, "carol", "a very good password")
+
+	wrong := map[string]string{"login": "carol", "password": "not the password"}
+	for i := 0; i < 3; i++ {
+		if rr := doJSON(t, r, "POST", "/api/v1/login", "", wrong); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("попытка %d: код %d, ожидалось 401", i+1, rr.Code)
+		}
+	}
+
+	// Даже верный пароль не принимается, пока логин заблокирован
+	right := map[string]string{"login": "carol", "password": "a very good password"}
+	rr := doJSON(t, r, "POST", "/api/v1/login", "", right)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("код %d, ожидалось 429", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("нет заголовка Retry-After")
+	}
+}
+
+func TestLoginStaleFailuresExpire(t *testing.T) {
+	saved := api.LoginThrottle
+	defer func() { api.LoginThrottle = saved }()
+	api.LoginThrottle.MaxFailures = 3
+	api.LoginThrottle.BaseLockout = time.Minute
+	api.LoginThrottle.FailureWindow = 15 * time.Minute
+
+	r, db, _ := newTestServer(t)
+	registerAndLogin(t, r, "erin", "a very good password")
+
+	wrong := map[string]string{"login": "erin", "password": "not the password"}
+	for i := 0; i < 2; i++ {
+		if rr := doJSON(t, r, "POST", "/api/v1/login", "", wrong); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("попытка %d: код %d, ожидалось 401", i+1, rr.Code)
+		}
+	}
+	// Последние неудачи были давно: счетчики по логину и IP устарели
+	if _, err := db.Exec("UPDATE login_failures SET last_failure = ?", time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := doJSON(t, r, "POST", "/api/v1/login", "", wrong); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("код %d, ожидалось 401", rr.Code)
+	}
+	right := map[string]string{"login": "erin", "password": "a very good password"}
+	if rr := doJSON(t, r, "POST", "/api/v1/login", "", right); rr.Code != http.StatusOK {
+		t.Fatalf("код %d, ожидалось 200: устаревший счетчик не должен блокировать", rr.Code)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM login_failures WHERE failures > 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("устаревших счетчиков осталось %d", n)
+	}
+}
+
+func TestLoginUnknownUserSameResponse(t *testing.T) {
+	r := newAuthRouter(t)
+	registerAndLogin(t, r, "dave", "dave's long password")
+
+	unknown := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "nobody", "password": "whatever123"})
+	wrong := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "dave", "password": "whatever123"})
+	if unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("ответы различаются: %d %q и %d %q",
+			unknown.Code, unknown.Body.String(), wrong.Code, wrong.Body.String())
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"calculator/internal/models"
+)
+
+func TestLongPollWakesOnCompletion(t *testing.T) {
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	id := submitExpression(t, r, user.Token, "2+3")
+
+	task, ok := h.GetTaskForAgent("agent-1")
+	if !ok {
+		t.Fatal("нет задачи для агента")
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		h.SubmitAgentResult("agent-1", task.Id, 5)
+	}()
+
+	start := time.Now()
+	rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"?wait=10s", user.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ожидание не прервано результатом: %v", elapsed)
+	}
+	var got struct {
+		Expression models.Expression `json:"expression"`
+	}
+	decodeJSON(t, rr, &got)
+	if got.Expression.Status != models.StatusCompleted || got.Expression.Result == nil || *got.Expression.Result != 5 {
+		t.Errorf("ожидался результат 5: %+v", got.Expression)
+	}
+
+	// Готовое выражение отдается сразу
+	start = time.Now()
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"?wait=10s", user.Token, nil); rr.Code != http.StatusOK || time.Since(start) > time.Second {
+		t.Errorf("готовое выражение: код %d за %v", rr.Code, time.Since(start))
+	}
+}
+
+func TestLongPollTimeoutAndCancel(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	id := submitExpression(t, r, user.Token, "2+3")
+
+	for _, q := range []string{"wait=soon", "wait=-1s", "wait=1h"} {
+		if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"?"+q, user.Token, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: код %d, ожидалось 400", q, rr.Code)
+		}
+	}
+
+	start := time.Now()
+	rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"?wait=300ms", user.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("код %d", rr.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("ответ раньше таймаута: %v", elapsed)
+	}
+
+	// Отмена запроса клиентом сразу освобождает ожидание
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/api/v1/expressions/"+id+"?wait=30s", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	finished := make(chan struct{})
+	go func() {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		close(finished)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ожидание не освобождено после отмены запроса")
+	}
+}
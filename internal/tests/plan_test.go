@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"calculator/internal/api"
+)
+
+func TestExpressionPlan(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	// (a+b)*(c-d)/2: сложение и вычитание параллельно (1000 мс),
+	// затем умножение (2000 мс) и деление (2000 мс)
+	rr := doJSON(t, r, "POST", "/api/v1/expressions/plan", user.Token, map[string]interface{}{
+		"expression": "( a + b ) * (c - d) / 2", "variables": map[string]float64{"a": 1, "b": 2, "c": 5, "d": 3},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("план: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Plan api.Plan `json:"plan"`
+	}
+	decodeJSON(t, rr, &resp)
+	p := resp.Plan
+	if p.Normalized != "(a+b)*(c-d)/2" || p.AST == nil || p.AST.Op != "/" {
+		t.Errorf("нормальная форма и дерево: %q %+v", p.Normalized, p.AST)
+	}
+	if p.TaskCount != 4 || len(p.Edges) != 3 || p.CriticalPathMS != 5000 || p.MaxParallelism != 2 {
+		t.Errorf("граф: задач %d, ребер %d, критический путь %d, параллельность %d",
+			p.TaskCount, len(p.Edges), p.CriticalPathMS, p.MaxParallelism)
+	}
+	last := p.Nodes[3]
+	if last.Operator != "/" || last.Arg1.Node == nil || *last.Arg1.Node != 2 || last.Arg2.Value == nil || *last.Arg2.Value != 2 || last.StartMS != 3000 {
+		t.Errorf("последний узел: %+v", last)
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&n)
+	if n != 0 {
+		t.Errorf("план сохранил %d выражений", n)
+	}
+
+	var syntaxErr struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Position int `json:"position"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/expressions/plan", user.Token, map[string]string{"expression": "1+(2*"})
+	decodeJSON(t, rr, &syntaxErr)
+	if rr.Code != http.StatusUnprocessableEntity || syntaxErr.Error.Code != "unexpected_end" || syntaxErr.Error.Details.Position != 5 {
+		t.Errorf("ошибка разбора: код %d, %+v", rr.Code, syntaxErr)
+	}
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+// Очередь готовых задач живет в памяти; после перезапуска оркестратора
+// ее восстанавливает NewHandler, а задачи упавших агентов возвращает
+// RequeueExpiredLeases
+func TestTaskQueueSurvivesRestart(t *testing.T) {
+	r, db, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	id := submitExpression(t, r, user.Token, "(1+2)*(3+4)")
+
+	// Первый агент берет задачу и падает, не вернув результат
+	lost, ok := h.GetTaskForAgent("agent-1")
+	if !ok {
+		t.Fatal("нет задачи до перезапуска")
+	}
+
+	// Перезапуск: новый Handler над той же БД
+	h = api.NewHandler(db)
+	task, ok := h.GetTaskForAgent("agent-2")
+	if !ok || task.Id == lost.Id {
+		t.Fatalf("после перезапуска: задача %v, есть %v", task, ok)
+	}
+	// Результат, который не поместился бы в %f
+	if err := h.SubmitAgentResult("agent-2", task.Id, 1e-9); err != nil {
+		t.Fatal(err)
+	}
+	if task, ok := h.GetTaskForAgent("agent-2"); ok {
+		t.Fatalf("выданная задача не должна вернуться в очередь раньше срока: %v", task)
+	}
+	if n, err := h.RequeueExpiredLeases(); err != nil || n != 0 {
+		t.Fatalf("до срока: %d, %v", n, err)
+	}
+
+	// Срок выдачи истек
+	leasedAt := time.Now().UTC().Add(-time.Hour)
+	if _, err := db.Exec("UPDATE tasks SET leased_at = ? WHERE id = ?", leasedAt, lost.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := h.RequeueExpiredLeases(); err != nil || n != 1 {
+		t.Fatalf("просроченная задача: %d, %v", n, err)
+	}
+	if err := h.SubmitAgentResult("agent-1", lost.Id, 3); err == nil {
+		t.Error("результат от агента, у которого истек срок, принят")
+	}
+	again, ok := h.GetTaskForAgent("agent-3")
+	if !ok || again.Id != lost.Id {
+		t.Fatalf("повторная выдача: %v, %v", again, ok)
+	}
+	if err := h.SubmitAgentResult("agent-3", again.Id, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Аргументы зависимой задачи передаются без потери точности
+	mul, ok := h.GetTaskForAgent("agent-3")
+	if !ok {
+		t.Fatal("нет задачи умножения")
+	}
+	if mul.Arg1 != "3" || mul.Arg2 != "1e-09" {
+		t.Errorf("аргументы умножения: %q %q", mul.Arg1, mul.Arg2)
+	}
+	if err := h.SubmitAgentResult("agent-3", mul.Id, 3e-9); err != nil {
+		t.Fatal(err)
+	}
+	var status models.CalculationStatus
+	if err := db.QueryRow("SELECT status FROM expressions WHERE id = ?", id).Scan(&status); err != nil || status != models.StatusCompleted {
+		t.Errorf("статус выражения: %s, %v", status, err)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+type searchResult struct {
+	Expressions []models.Expression `json:"expressions"`
+	Total       int                 `json:"total"`
+	Facets      struct {
+		Tags []api.TagFacet `json:"tags"`
+	} `json:"facets"`
+	Mode string `json:"mode"`
+}
+
+// search выполняет поиск и возвращает id найденных выражений по порядку
+func search(t *testing.T, r http.Handler, token string, params url.Values) ([]string, searchResult) {
+	t.Helper()
+	rr := doJSON(t, r, "GET", "/api/v1/expressions/search?"+params.Encode(), token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("поиск %v: код %d, тело %s", params, rr.Code, rr.Body.String())
+	}
+	var res searchResult
+	decodeJSON(t, rr, &res)
+	ids := []string{}
+	for _, e := range res.Expressions {
+		ids = append(ids, e.ID)
+	}
+	return ids, res
+}
+
+func TestExpressionMetadata(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	reader := registerAndLogin(t, r, "petr", "petr's long password")
+
+	id := submitCalculation(t, r, owner.Token, map[string]interface{}{
+		"expression": "3*4", "title": "Площадь комнаты", "notes": "черновик", "tags": []string{" Дом ", "дом", "ремонт"}})
+	var got struct {
+		Expression models.Expression `json:"expression"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+id, owner.Token, nil), &got)
+	if got.Expression.Title != "Площадь комнаты" || got.Expression.Notes != "черновик" ||
+		!reflect.DeepEqual(got.Expression.Tags, []string{"дом", "ремонт"}) {
+		t.Errorf("метаданные после отправки: %+v", got.Expression)
+	}
+
+	// Частичное изменение: notes меняются, title очищается, остальное не трогается
+	rr := doJSON(t, r, "PATCH", "/api/v1/expressions/"+id, owner.Token, map[string]interface{}{"notes": "без балкона", "title": nil})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PATCH: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var patched struct {
+		Expression models.Expression `json:"expression"`
+	}
+	decodeJSON(t, rr, &patched)
+	if patched.Expression.Title != "" || patched.Expression.Notes != "без балкона" || len(patched.Expression.Tags) != 2 {
+		t.Errorf("после PATCH: %+v", patched.Expression)
+	}
+	rr = doJSON(t, r, "GET", "/api/v1/expressions/"+id, owner.Token, nil)
+	if !strings.Contains(rr.Body.String(), "без балкона") || strings.Contains(rr.Body.String(), `"title"`) {
+		t.Errorf("PATCH не сохранен: %s", rr.Body.String())
+	}
+
+	for name, body := range map[string]interface{}{
+		"поле только для чтения": map[string]string{"expression": "1+1"},
+		"недопустимая метка":     map[string][]string{"tags": {"два слова"}},
+		"слишком длинный title":  map[string]string{"title": strings.Repeat("я", api.MaxTitleLength+1)},
+	} {
+		if rr := doJSON(t, r, "PATCH", "/api/v1/expressions/"+id, owner.Token, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: код %d, ожидалось 400", name, rr.Code)
+		}
+	}
+
+	// Право чтения не дает менять заметки
+	doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/grants", owner.Token, map[string]string{"login": "petr"})
+	if rr := doJSON(t, r, "PATCH", "/api/v1/expressions/"+id, reader.Token, map[string]string{"title": "мое"}); rr.Code != http.StatusNotFound {
+		t.Errorf("PATCH читателем: код %d, ожидалось 404", rr.Code)
+	}
+
+	// Проверка при отправке
+	tags := make([]string, api.MaxTags+1)
+	for i := range tags {
+		tags[i] = "t" + strings.Repeat("x", i)
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/calculate", owner.Token, map[string]interface{}{"expression": "1+1", "tags": tags})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"parameter":"tags"`) {
+		t.Errorf("слишком много меток: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSearchExpressions(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+
+	room := submitCalculation(t, r, owner.Token, map[string]interface{}{
+		"expression": "3*4", "title": "Площадь комнаты", "tags": []string{"дом", "ремонт"}})
+	paint := submitCalculation(t, r, owner.Token, map[string]interface{}{
+		"expression": "12/8", "title": "Краска", "notes": "банки на стены комнаты", "tags": []string{"ремонт"}})
+	submitCalculation(t, r, owner.Token, map[string]interface{}{"expression": "1500*12", "title": "Аренда", "tags": []string{"финансы"}})
+	submitCalculation(t, r, other.Token, map[string]interface{}{"expression": "7*7", "title": "Площадь участка", "tags": []string{"дом"}})
+
+	// Слово из заголовка одного выражения и из заметок другого
+	ids, res := search(t, r, owner.Token, url.Values{"q": {"комнаты"}})
+	sort.Strings(ids)
+	want := []string{room, paint}
+	sort.Strings(want)
+	if res.Total != 2 || !reflect.DeepEqual(ids, want) {
+		t.Errorf("поиск по заголовку и заметкам: %v, total %d", ids, res.Total)
+	}
+	ids, _ = search(t, r, owner.Token, url.Values{"q": {"Площадь комнаты"}})
+	if !reflect.DeepEqual(ids, []string{room}) {
+		t.Errorf("поиск по заголовку: %v", ids)
+	}
+	// Текст выражения
+	if ids, _ = search(t, r, owner.Token, url.Values{"q": {"1500"}}); len(ids) != 1 {
+		t.Errorf("поиск по выражению: %v", ids)
+	}
+
+	// Метки сужают поиск, фасеты — по всем найденным
+	ids, res = search(t, r, owner.Token, url.Values{"tag": {"ремонт"}})
+	if res.Total != 2 || len(ids) != 2 {
+		t.Errorf("поиск по метке: %v", ids)
+	}
+	facets := []api.TagFacet{{Tag: "ремонт", Count: 2}, {Tag: "дом", Count: 1}}
+	if !reflect.DeepEqual(res.Facets.Tags, facets) {
+		t.Errorf("фасеты: %+v, ожидалось %+v", res.Facets.Tags, facets)
+	}
+	if ids, _ = search(t, r, owner.Token, url.Values{"tag": {"ремонт", "дом"}}); !reflect.DeepEqual(ids, []string{room}) {
+		t.Errorf("две метки: %v", ids)
+	}
+	ids, res = search(t, r, owner.Token, url.Values{"tag": {"ремонт"}, "limit": {"1"}, "offset": {"1"}})
+	if res.Total != 2 || len(ids) != 1 || len(res.Facets.Tags) != 2 {
+		t.Errorf("страница поиска: %v, %+v", ids, res)
+	}
+
+	// Изменение заметок сразу видно в поиске
+	doJSON(t, r, "PATCH", "/api/v1/expressions/"+paint, owner.Token, map[string]interface{}{"notes": nil})
+	if ids, _ = search(t, r, owner.Token, url.Values{"q": {"комнаты"}}); !reflect.DeepEqual(ids, []string{room}) {
+		t.Errorf("после очистки заметок: %v", ids)
+	}
+
+	// Чужие выражения не находятся
+	if ids, _ = search(t, r, other.Token, url.Values{"q": {"Краска"}}); len(ids) != 0 {
+		t.Errorf("чужие выражения: %v", ids)
+	}
+	if _, res = search(t, r, other.Token, url.Values{"q": {"Площадь"}}); res.Total != 1 || len(res.Facets.Tags) != 1 {
+		t.Errorf("поиск другого пользователя: %+v", res)
+	}
+
+	for _, q := range []string{"", "q=", "q=%2B%2B", "tag=два%20слова", "q=x&limit=0"} {
+		if rr := doJSON(t, r, "GET", "/api/v1/expressions/search?"+q, owner.Token, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: код %d, ожидалось 400", q, rr.Code)
+		}
+	}
+
+	// Ответ сообщает, каким способом шел поиск
+	wantMode := api.SearchModeLike
+	if models.FullTextSearch(db) {
+		wantMode = api.SearchModeFullText
+	}
+	if _, res = search(t, r, owner.Token, url.Values{"q": {"Краска"}}); res.Mode != wantMode {
+		t.Errorf("mode %q, ожидалось %q", res.Mode, wantMode)
+	}
+
+	// С FTS5 поиск идет по словам без учета регистра и по префиксу,
+	// кавычки и операторы FTS5 в q не ломают запрос
+	if !models.FullTextSearch(db) {
+		t.Log("драйвер собран без sqlite_fts5, проверен поиск через LIKE")
+		return
+	}
+	if ids, _ = search(t, r, owner.Token, url.Values{"q": {"ПЛОЩ"}}); !reflect.DeepEqual(ids, []string{room}) {
+		t.Errorf("префикс без учета регистра: %v", ids)
+	}
+	if ids, _ = search(t, r, owner.Token, url.Values{"q": {`"аренда" OR NEAR(`}}); len(ids) != 0 {
+		t.Errorf("операторы в q: %v", ids)
+	}
+}
+
+func TestSearchIndexRebuiltAfterRowidChange(t *testing.T) {
+	r, db, _ := newTestServer(t)
+	if !models.FullTextSearch(db) {
+		t.Skip("драйвер собран без sqlite_fts5")
+	}
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	room := submitCalculation(t, r, owner.Token, map[string]interface{}{"expression": "3*4", "title": "Площадь комнаты"})
+	submitCalculation(t, r, owner.Token, map[string]interface{}{"expression": "12/8", "title": "Краска"})
+
+	// Так VACUUM может перенумеровать строки таблицы без INTEGER PRIMARY KEY;
+	// триггеры индекса на изменение rowid не срабатывают
+	if _, err := db.Exec("UPDATE expressions SET rowid = rowid + 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := search(t, r, owner.Token, url.Values{"q": {"комнаты"}}); !reflect.DeepEqual(ids, []string{room}) {
+		t.Errorf("поиск после перенумерации: %v", ids)
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// submitExpression отправляет выражение и возвращает его id
+func submitExpression(t *testing.T, r http.Handler, token, expression string) string {
+	t.Helper()
+	return submitCalculation(t, r, token, map[string]string{"expression": expression})
+}
+
+// submitCalculation отправляет произвольное тело POST /api/v1/calculate
+func submitCalculation(t *testing.T, r http.Handler, token string, req interface{}) string {
+	t.Helper()
+	rr := doJSON(t, r, "POST", "/api/v1/calculate", token, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("отправка выражения: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	decodeJSON(t, rr, &resp)
+	return resp.ID
+}
+
+func TestShareGrant(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	colleague := registerAndLogin(t, r, "petr", "petr's long password")
+	id := submitExpression(t, r, owner.Token, "2+2")
+
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id, colleague.Token, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("без права чтения: код %d, ожидалось 404", rr.Code)
+	}
+	// Коллега не может раздавать чужое выражение
+	if rr := doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/grants", colleague.Token, map[string]string{"login": "petr"}); rr.Code != http.StatusNotFound {
+		t.Errorf("выдача права не владельцем: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/grants", owner.Token, map[string]string{"login": "nobody"}); rr.Code != http.StatusNotFound {
+		t.Errorf("несуществующий логин: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/grants", owner.Token, map[string]string{"login": "petr"}); rr.Code != http.StatusCreated {
+		t.Fatalf("выдача права: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id, colleague.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("с правом чтения: код %d", rr.Code)
+	}
+	rr := doJSON(t, r, "GET", "/api/v1/expressions/shared-with-me", colleague.Token, nil)
+	if !strings.Contains(rr.Body.String(), id) {
+		t.Errorf("выражение не попало в shared-with-me: %s", rr.Body.String())
+	}
+
+	if rr := doJSON(t, r, "DELETE", "/api/v1/expressions/"+id+"/grants/petr", owner.Token, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("отзыв права: код %d", rr.Code)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id, colleague.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("после отзыва: код %d, ожидалось 404", rr.Code)
+	}
+}
+
+// Читатель по выданному праву видит выражение и результат, но не владельца,
+// переменные и записную книжку владельца
+func TestShareGrantHidesOwnerFields(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	colleague := registerAndLogin(t, r, "petr", "petr's long password")
+	id := submitCalculation(t, r, owner.Token, map[string]interface{}{
+		"expression": "a*2", "variables": map[string]float64{"a": 21}, "ref": "invoice-7",
+		"title": "Зарплата", "notes": "не показывать", "tags": []string{"личное"}})
+	doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/grants", owner.Token, map[string]string{"login": "petr"})
+
+	ownerOnly := []string{`"user_id"`, `"variables"`, `"ref"`, `"title"`, `"notes"`, `"tags"`, "invoice-7", "Зарплата", "личное"}
+	for _, path := range []string{"/api/v1/expressions/" + id, "/api/v1/expressions/shared-with-me"} {
+		rr := doJSON(t, r, "GET", path, colleague.Token, nil)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"a*2"`) {
+			t.Fatalf("%s: код %d, тело %s", path, rr.Code, rr.Body.String())
+		}
+		for _, field := range ownerOnly {
+			if strings.Contains(rr.Body.String(), field) {
+				t.Errorf("%s: читателю виден %s: %s", path, field, rr.Body.String())
+			}
+		}
+	}
+	var c calculationV2
+	rr := doJSON(t, r, "GET", "/api/v2/calculations/"+id, colleague.Token, nil)
+	decodeJSON(t, rr, &c)
+	if c.Expression != "a*2" || c.OwnerID != nil || c.Title != nil || c.Ref != nil || len(c.Variables) != 0 || strings.Contains(rr.Body.String(), "не показывать") {
+		t.Errorf("v2 для читателя: %s", rr.Body.String())
+	}
+
+	// Владелец видит все
+	rr = doJSON(t, r, "GET", "/api/v1/expressions/"+id, owner.Token, nil)
+	for _, field := range ownerOnly {
+		if !strings.Contains(rr.Body.String(), field) {
+			t.Errorf("владельцу не виден %s: %s", field, rr.Body.String())
+		}
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	id := submitExpression(t, r, owner.Token, "2+2")
+
+	rr := doJSON(t, r, "POST", "/api/v1/expressions/"+id+"/links", owner.Token, map[string]string{"expires_in": "1h"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("создание ссылки: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var link struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	decodeJSON(t, rr, &link)
+
+	rr = doJSON(t, r, "GET", link.URL, "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("публичная ссылка: код %d", rr.Code)
+	}
+	var shared map[string]interface{}
+	decodeJSON(t, rr, &shared)
+	if shared["expression"] != "2+2" || shared["status"] == nil {
+		t.Errorf("неверное содержимое ссылки: %v", shared)
+	}
+	for _, field := range []string{"id", "user_id"} {
+		if _, ok := shared[field]; ok {
+			t.Errorf("по ссылке не должно быть поля %s", field)
+		}
+	}
+
+	if rr := doJSON(t, r, "GET", "/api/v1/shared/guessed-token", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("неизвестный токен: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "DELETE", "/api/v1/expressions/"+id+"/links/"+link.ID, owner.Token, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("отзыв ссылки: код %d", rr.Code)
+	}
+	if rr := doJSON(t, r, "GET", link.URL, "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("отозванная ссылка: код %d, ожидалось 404", rr.Code)
+	}
+}
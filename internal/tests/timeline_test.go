@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"calculator/internal/api"
+	"calculator/internal/models"
+)
+
+func TestExpressionTimeline(t *testing.T) {
+	r, _, h := newTestServer(t)
+
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+	id := submitExpression(t, r, user.Token, "2+3")
+
+	var got struct {
+		Expression models.Expression `json:"expression"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+id, user.Token, nil), &got)
+	if got.Expression.CreatedAt == nil || got.Expression.StartedAt != nil || got.Expression.TaskCount != 1 {
+		t.Fatalf("новое выражение: %+v", got.Expression)
+	}
+
+	task, ok := h.GetTaskForAgent("agent-1")
+	if !ok {
+		t.Fatal("нет задачи для агента")
+	}
+	// Результат принимается только от агента, получившего задачу
+	if err := h.SubmitAgentResult("agent-2", task.Id, 5); err == nil {
+		t.Error("результат от чужого агента принят")
+	}
+	if err := h.SubmitAgentResult("agent-1", task.Id, 5); err != nil {
+		t.Fatalf("результат задачи: %v", err)
+	}
+	if err := h.SubmitAgentResult("agent-1", task.Id, 5); err == nil {
+		t.Error("повторный результат принят")
+	}
+
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+id, user.Token, nil), &got)
+	e := got.Expression
+	if e.Status != models.StatusCompleted || e.Result == nil || *e.Result != 5 || e.TasksDone != 1 {
+		t.Fatalf("завершенное выражение: %+v", e)
+	}
+	if e.StartedAt == nil || e.CompletedAt == nil || e.CompletedAt.Before(*e.StartedAt) || e.StartedAt.Before(*e.CreatedAt) {
+		t.Errorf("неверные отметки времени: %+v", e)
+	}
+
+	rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"/timeline", user.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("timeline: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var timeline struct {
+		Tasks []api.TaskTimeline `json:"tasks"`
+	}
+	decodeJSON(t, rr, &timeline)
+	if len(timeline.Tasks) != 1 {
+		t.Fatalf("ожидалась 1 задача, получено %d", len(timeline.Tasks))
+	}
+	tt := timeline.Tasks[0]
+	if tt.ID != task.Id || tt.AgentID != "agent-1" || tt.Operation != "+" {
+		t.Errorf("неверная задача: %+v", tt)
+	}
+	if tt.LeasedAt == nil || tt.CompletedAt == nil || tt.LeasedAt.Before(tt.EnqueuedAt) {
+		t.Errorf("неверные отметки задачи: %+v", tt)
+	}
+
+	if rr := doJSON(t, r, "GET", "/api/v1/expressions/"+id+"/timeline", other.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("чужой timeline: код %d, ожидалось 404", rr.Code)
+	}
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"calculator/calculatorpb"
+	"calculator/internal"
+	"calculator/internal/agentauth"
+	"calculator/internal/tlsconfig"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func genTestCerts(t *testing.T) *tlsconfig.DevCertsFiles {
+	t.Helper()
+	files, err := tlsconfig.GenerateDevCerts(tlsconfig.DevCertsOptions{
+		Dir:    t.TempDir(),
+		Agents: []string{"agent-1"},
+	})
+	if err != nil {
+		t.Fatalf("Ошибка генерации сертификатов: %v", err)
+	}
+	return files
+}
+
+func TestHTTPSWithDevCA(t *testing.T) {
+	files := genTestCerts(t)
+	serverTLS, err := tlsconfig.Server(tlsconfig.ServerConfig{CertFile: files.Server, KeyFile: files.ServerKey, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = serverTLS
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientTLS, err := tlsconfig.Client(tlsconfig.ClientConfig{CAFile: files.CA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("запрос с CA разработки: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("ожидалось соединение TLS 1.3")
+	}
+
+	// Без нашего CA сертификат сервера не проходит проверку
+	if _, err := http.Get(srv.URL); err == nil {
+		t.Error("сертификат локального CA не должен приниматься без ORCHESTRATOR_CA_FILE")
+	}
+}
+
+func TestAgentMTLS(t *testing.T) {
+	files := genTestCerts(t)
+	serverTLS, err := tlsconfig.Server(tlsconfig.ServerConfig{
+		CertFile: files.Server, KeyFile: files.ServerKey, ClientCAFile: files.CA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(bufSize)
+	seen := make(chan string, 1)
+	tokens, _ := agentauth.NewTokens(map[string]string{"token-agent": "token agent secret"})
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(tokens.UnaryServerInterceptor()),
+		grpc.Creds(credentials.NewTLS(serverTLS)))
+	calculatorpb.RegisterAgentServiceServer(server, &internal.AgentServiceServerImpl{
+		TaskProvider: func(agentID string) (*calculatorpb.Task, bool) {
+			seen <- agentID
+			return nil, false
+		},
+	})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	dial := func(cfg tlsconfig.ClientConfig) (calculatorpb.AgentServiceClient, func()) {
+		cfg.CAFile = files.CA
+		cfg.ServerName = "localhost"
+		clientTLS, err := tlsconfig.Client(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := grpc.DialContext(context.Background(), "bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return calculatorpb.NewAgentServiceClient(conn), func() { conn.Close() }
+	}
+
+	// Сертификат агента заменяет токен, имя агента — CommonName
+	agent := files.Agents["agent-1"]
+	client, closeConn := dial(tlsconfig.ClientConfig{CertFile: agent[0], KeyFile: agent[1]})
+	defer closeConn()
+	if _, err := client.GetTask(context.Background(), &calculatorpb.GetTaskRequest{}); err != nil {
+		t.Fatalf("GetTask с сертификатом агента: %v", err)
+	}
+	if got := <-seen; got != "agent-1" {
+		t.Errorf("агент %q, ожидался agent-1", got)
+	}
+
+	// Без клиентского сертификата рукопожатие не проходит
+	client, closeConn = dial(tlsconfig.ClientConfig{})
+	defer closeConn()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.GetTask(ctx, &calculatorpb.GetTaskRequest{}); err == nil {
+		t.Error("вызов без клиентского сертификата должен отклоняться")
+	}
+}
+
+// На HTTPS сертификат агента необязателен: без него работает токен,
+// с ним агент опознается по CommonName
+func TestAgentHTTPClientCert(t *testing.T) {
+	files := genTestCerts(t)
+	serverTLS, err := tlsconfig.Server(tlsconfig.ServerConfig{
+		CertFile: files.Server, KeyFile: files.ServerKey, ClientCAFile: files.CA, ClientCertOptional: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, _ := agentauth.NewTokens(map[string]string{"token-agent": "token agent secret"})
+	srv := httptest.NewUnstartedServer(tokens.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := agentauth.Identity(r.Context())
+		w.Write([]byte(name))
+	})))
+	srv.TLS = serverTLS
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cfg tlsconfig.ClientConfig, token string) (int, string) {
+		t.Helper()
+		cfg.CAFile = files.CA
+		clientTLS, err := tlsconfig.Client(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		if token != "" {
+			req.Header.Set(agentauth.HeaderName, token)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("запрос: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	agent := files.Agents["agent-1"]
+	if code, name := get(tlsconfig.ClientConfig{CertFile: agent[0], KeyFile: agent[1]}, ""); code != http.StatusOK || name != "agent-1" {
+		t.Errorf("с сертификатом агента: код %d, агент %q", code, name)
+	}
+	if code, name := get(tlsconfig.ClientConfig{}, "token agent secret"); code != http.StatusOK || name != "token-agent" {
+		t.Errorf("с токеном: код %d, агент %q", code, name)
+	}
+	if code, _ := get(tlsconfig.ClientConfig{}, ""); code != http.StatusUnauthorized {
+		t.Errorf("без сертификата и токена: код %d, ожидалось 401", code)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	if _, err := tlsconfig.ParseMinVersion("1.0"); err == nil {
+		t.Error("TLS 1.0 не должен поддерживаться")
+	}
+	if v, err := tlsconfig.ParseMinVersion(""); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("по умолчанию ожидался TLS 1.2, получено %x %v", v, err)
+	}
+}
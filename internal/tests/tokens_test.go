@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type loginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func newAuthRouter(t *testing.T) *mux.Router {
+	r, _, _ := newTestServer(t)
+	return r
+}
+
+func registerAndLogin(t *testing.T, r http.Handler, login, password string) loginResponse {
+	t.Helper()
+	creds := map[string]string{"login": login, "password": password}
+	if rr := doJSON(t, r, "POST", "/api/v1/register", "", creds); rr.Code != http.StatusOK {
+		t.Fatalf("регистрация: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	rr := doJSON(t, r, "POST", "/api/v1/login", "", creds)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("логин: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var resp loginResponse
+	decodeJSON(t, rr, &resp)
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("в ответе нет токенов: %s", rr.Body.String())
+	}
+	return resp
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	r := newAuthRouter(t)
+	first := registerAndLogin(t, r, "alice", "correct horse battery")
+
+	rr := doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": first.RefreshToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("обновление: код %d", rr.Code)
+	}
+	var second loginResponse
+	decodeJSON(t, rr, &second)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh токен должен меняться при каждом обновлении")
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/me", second.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("новый access токен не принят: код %d", rr.Code)
+	}
+
+	// Повторное использование старого токена убивает все семейство
+	rr = doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": first.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("повторное использование: код %d, ожидалось 401", rr.Code)
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": second.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("токен из отозванного семейства: код %d, ожидалось 401", rr.Code)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	r := newAuthRouter(t)
+	tokens := registerAndLogin(t, r, "bob", "another long password")
+
+	if rr := doJSON(t, r, "GET", "/api/v1/me", tokens.Token, nil); rr.Code != http.StatusOK {
+		t.Fatalf("до выхода: код %d", rr.Code)
+	}
+	rr := doJSON(t, r, "POST", "/api/v1/logout", tokens.Token, map[string]string{"refresh_token": tokens.RefreshToken})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("выход: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/me", tokens.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("отозванный access токен: код %d, ожидалось 401", rr.Code)
+	}
+	rr = doJSON(t, r, "POST", "/api/v1/token/refresh", "", map[string]string{"refresh_token": tokens.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh после выхода: код %d, ожидалось 401", rr.Code)
+	}
+}
+
+func TestLoginUpgradesLegacyPasswordHash(t *testing.T) {
+	r, db, _ := newTestServer(t)
+
+	// Пользователь из старой версии с несоленым SHA-256 хешем
+	legacy := "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8" // "password"
+	if _, err := db.Exec("INSERT INTO users (id, login, password) VALUES ('u1', 'old', ?)", legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "old", "password": "password"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("логин со старым хешем: код %d", rr.Code)
+	}
+	var stored string
+	db.QueryRow("SELECT password FROM users WHERE id = 'u1'").Scan(&stored)
+	if !strings.HasPrefix(stored, "$argon2id$") {
+		t.Errorf("хеш не обновлен после входа: %s", stored)
+	}
+
+	rr = doJSON(t, r, "POST", "/api/v1/login", "", map[string]string{"login": "old", "password": "password"})
+	if rr.Code != http.StatusOK {
+		t.Errorf("логин с обновленным хешем: код %d", rr.Code)
+	}
+}
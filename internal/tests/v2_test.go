@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"calculator/internal/api"
+)
+
+type calculationV2 struct {
+	ID           string             `json:"id"`
+	Expression   string             `json:"expression"`
+	Variables    map[string]float64 `json:"variables"`
+	Status       string             `json:"status"`
+	Result       *float64           `json:"result"`
+	Ref          *string            `json:"ref"`
+	Title        *string            `json:"title"`
+	Tags         []string           `json:"tags"`
+	OwnerID      *string            `json:"owner_id"`
+	StatusDetail struct {
+		TasksTotal int     `json:"tasks_total"`
+		TasksDone  int     `json:"tasks_done"`
+		Progress   float64 `json:"progress"`
+		Reason     *string `json:"reason"`
+	} `json:"status_detail"`
+}
+
+// doWithHeader — doJSON с дополнительным заголовком
+func doWithHeader(t *testing.T, r http.Handler, method, path, token, header, value string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(header, value)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// Параллельные PATCH с одним If-Match: изменение применяет только первый,
+// остальные видят уже измененный ресурс
+func TestCalculationPatchIfMatchRace(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	rr := doJSON(t, r, "POST", "/api/v2/calculations", user.Token, map[string]string{"expression": "1+2"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("создание: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var c calculationV2
+	decodeJSON(t, rr, &c)
+	tag := rr.Header().Get("ETag")
+
+	const writers = 8
+	codes := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "If-Match", tag, `{"ref":"writer-`+strconv.Itoa(i)+`"}`)
+			codes <- rr.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	applied := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			applied++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("PATCH: код %d", code)
+		}
+	}
+	if applied != 1 {
+		t.Errorf("применено изменений: %d, ожидалось 1", applied)
+	}
+}
+
+func TestCalculationsV2(t *testing.T) {
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	rr := doJSON(t, r, "POST", "/api/v2/calculations", user.Token, map[string]interface{}{
+		"expression": "(a+1)*2", "variables": map[string]float64{"a": 3}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("создание: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var c calculationV2
+	decodeJSON(t, rr, &c)
+	if rr.Header().Get("Location") != "/api/v2/calculations/"+c.ID || rr.Header().Get("ETag") == "" {
+		t.Errorf("заголовки создания: %v", rr.Header())
+	}
+	// Поля без значения — null, а не пропущены
+	if !strings.Contains(rr.Body.String(), `"result":null`) || !strings.Contains(rr.Body.String(), `"ref":null`) ||
+		!strings.Contains(rr.Body.String(), `"tags":[]`) {
+		t.Errorf("пропущенные поля: %s", rr.Body.String())
+	}
+	if c.Status != "pending" || c.StatusDetail.TasksTotal != 2 || c.StatusDetail.Progress != 0 || c.Variables["a"] != 3 {
+		t.Errorf("вычисление: %+v", c)
+	}
+
+	// Условный GET
+	rr = doJSON(t, r, "GET", "/api/v2/calculations/"+c.ID, user.Token, nil)
+	tag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || tag == "" {
+		t.Fatalf("чтение: код %d, ETag %q", rr.Code, tag)
+	}
+	if rr = doWithHeader(t, r, "GET", "/api/v2/calculations/"+c.ID, user.Token, "If-None-Match", tag, ""); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("If-None-Match: код %d, тело %q", rr.Code, rr.Body.String())
+	}
+
+	// Задача умножения ждет сложения
+	var tasks struct {
+		Items []struct {
+			State string   `json:"state"`
+			Arg1  *float64 `json:"arg1"`
+		} `json:"items"`
+		Total int `json:"total"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v2/calculations/"+c.ID+"/tasks", user.Token, nil), &tasks)
+	if tasks.Total != 2 || tasks.Items[0].State != api.TaskQueued || tasks.Items[1].State != api.TaskWaiting || tasks.Items[1].Arg1 != nil {
+		t.Errorf("задачи: %+v", tasks)
+	}
+	task, ok := h.GetTaskForAgent("agent-1")
+	if !ok {
+		t.Fatal("нет задачи")
+	}
+	if err := h.SubmitAgentResult("agent-1", task.Id, 4); err != nil {
+		t.Fatal(err)
+	}
+	// После результата ресурс изменился, старый ETag не подходит
+	if rr = doWithHeader(t, r, "GET", "/api/v2/calculations/"+c.ID, user.Token, "If-None-Match", tag, ""); rr.Code != http.StatusOK {
+		t.Errorf("измененный ресурс: код %d", rr.Code)
+	}
+	decodeJSON(t, rr, &c)
+	if c.Status != "processing" || c.StatusDetail.Progress != 0.5 {
+		t.Errorf("ход вычисления: %+v", c)
+	}
+	tag = rr.Header().Get("ETag")
+
+	// PATCH метаданных с If-Match
+	if rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "If-Match", `"stale"`, `{"ref":"invoice-7"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("устаревший If-Match: код %d", rr.Code)
+	}
+	// If-Match сравнивает строго: слабый ETag не подходит, в If-None-Match — подходит
+	if rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "If-Match", "W/"+tag, `{"ref":"invoice-7"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("слабый If-Match: код %d", rr.Code)
+	}
+	if rr = doWithHeader(t, r, "GET", "/api/v2/calculations/"+c.ID, user.Token, "If-None-Match", "W/"+tag, ""); rr.Code != http.StatusNotModified {
+		t.Errorf("слабый If-None-Match: код %d", rr.Code)
+	}
+	rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "If-Match", tag, `{"ref":"invoice-7"}`)
+	decodeJSON(t, rr, &c)
+	if rr.Code != http.StatusOK || c.Ref == nil || *c.Ref != "invoice-7" || rr.Header().Get("ETag") == tag {
+		t.Errorf("PATCH: код %d, %+v", rr.Code, c)
+	}
+	if rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "Content-Type", "application/json", `{"status":"completed"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("изменение статуса: код %d", rr.Code)
+	}
+
+	// v1 видит то же хранилище
+	var v1 struct {
+		Expression struct {
+			Ref       string `json:"ref"`
+			TasksDone int    `json:"tasks_done"`
+		} `json:"expression"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/expressions/"+c.ID, user.Token, nil), &v1)
+	if v1.Expression.Ref != "invoice-7" || v1.Expression.TasksDone != 1 {
+		t.Errorf("v1: %+v", v1)
+	}
+
+	// null очищает поле
+	rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "Content-Type", "application/merge-patch+json", `{"ref":null}`)
+	decodeJSON(t, rr, &c)
+	if c.Ref != nil {
+		t.Errorf("ref после null: %v", *c.Ref)
+	}
+
+	// Заголовок и метки меняются тем же PATCH
+	rr = doWithHeader(t, r, "PATCH", "/api/v2/calculations/"+c.ID, user.Token, "Content-Type", "application/merge-patch+json",
+		`{"title":"Счет","tags":["Отчет"]}`)
+	decodeJSON(t, rr, &c)
+	if c.Title == nil || *c.Title != "Счет" || len(c.Tags) != 1 || c.Tags[0] != "отчет" {
+		t.Errorf("PATCH title и tags: %s", rr.Body.String())
+	}
+}
+
+func TestCalculationsV2Access(t *testing.T) {
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	reader := registerAndLogin(t, r, "petr", "petr's long password")
+	var c calculationV2
+	decodeJSON(t, doJSON(t, r, "POST", "/api/v2/calculations", owner.Token, map[string]string{"expression": "2*3"}), &c)
+
+	if rr := doJSON(t, r, "GET", "/api/v2/calculations/"+c.ID, reader.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("чужое вычисление: код %d", rr.Code)
+	}
+	doJSON(t, r, "POST", "/api/v1/expressions/"+c.ID+"/grants", owner.Token, map[string]string{"login": "petr"})
+	if rr := doJSON(t, r, "GET", "/api/v2/calculations/"+c.ID, reader.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("выданное на чтение: код %d", rr.Code)
+	}
+	// Право чтения не дает менять метаданные
+	if rr := doJSON(t, r, "PATCH", "/api/v2/calculations/"+c.ID, reader.Token, map[string]string{"ref": "x"}); rr.Code != http.StatusNotFound {
+		t.Errorf("PATCH читателем: код %d", rr.Code)
+	}
+
+	var page struct {
+		Items      []calculationV2 `json:"items"`
+		NextCursor *string         `json:"next_cursor"`
+		Total      int             `json:"total"`
+	}
+	rr := doJSON(t, r, "GET", "/api/v2/calculations", owner.Token, nil)
+	decodeJSON(t, rr, &page)
+	if page.Total != 1 || len(page.Items) != 1 || page.NextCursor != nil || !strings.Contains(rr.Body.String(), `"next_cursor":null`) {
+		t.Errorf("список: %s", rr.Body.String())
+	}
+	if rr := doWithHeader(t, r, "GET", "/api/v2/calculations", owner.Token, "If-None-Match", rr.Header().Get("ETag"), ""); rr.Code != http.StatusNotModified {
+		t.Errorf("условный GET списка: код %d", rr.Code)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v2/calculations?limit=0", owner.Token, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: код %d", rr.Code)
+	}
+}
@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+)
+
+// webhookReceiver — получатель, который отвечает кодами из statuses
+// по очереди и проверяет подпись каждого запроса
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	statuses []int
+	events   []string
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	ts, _ := strconv.ParseInt(r.Header.Get(api.WebhookTimestampHeader), 10, 64)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if got := r.Header.Get(api.WebhookSignatureHeader); got != api.SignWebhook(rcv.secret, ts, body) {
+		rcv.t.Errorf("неверная подпись %q", got)
+	}
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	if status == http.StatusOK {
+		rcv.events = append(rcv.events, r.Header.Get(api.WebhookEventHeader))
+	}
+	w.WriteHeader(status)
+}
+
+// fastWebhooks ускоряет повторы доставки и разрешает получателей на 127.0.0.1
+func fastWebhooks(t *testing.T) {
+	oldBackoff, oldAttempts, oldPrivate := api.WebhookBackoff, api.WebhookMaxAttempts, api.WebhookAllowPrivate
+	api.WebhookBackoff, api.WebhookMaxAttempts, api.WebhookAllowPrivate = 0, 3, true
+	t.Cleanup(func() {
+		api.WebhookBackoff, api.WebhookMaxAttempts, api.WebhookAllowPrivate = oldBackoff, oldAttempts, oldPrivate
+	})
+}
+
+type webhookCreated struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+func createWebhook(t *testing.T, r http.Handler, token, url string, events []string) webhookCreated {
+	t.Helper()
+	rr := doJSON(t, r, "POST", "/api/v1/webhooks", token, map[string]interface{}{"url": url, "events": events})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("создание вебхука: код %d, тело %s", rr.Code, rr.Body.String())
+	}
+	var hook webhookCreated
+	decodeJSON(t, rr, &hook)
+	return hook
+}
+
+type deliveriesPage struct {
+	Deliveries []api.WebhookDelivery `json:"deliveries"`
+}
+
+// computeExpression отправляет выражение и присылает результат его единственной задачи
+func computeExpression(t *testing.T, r http.Handler, h *api.Handler, token, expression string, result float64) {
+	t.Helper()
+	submitExpression(t, r, token, expression)
+	task, ok := h.GetTaskForAgent("agent-1")
+	if !ok {
+		t.Fatal("нет задачи для агента")
+	}
+	if err := h.SubmitAgentResult("agent-1", task.Id, result); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookRetryAndDeadLetter(t *testing.T) {
+	fastWebhooks(t)
+	r, _, h := newTestServer(t)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	if rr := doJSON(t, r, "POST", "/api/v1/webhooks", user.Token, map[string]interface{}{"url": "ftp://example.com"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("неверный url: код %d, ожидалось 400", rr.Code)
+	}
+	if rr := doJSON(t, r, "POST", "/api/v1/webhooks", user.Token, map[string]interface{}{"url": "http://example.com", "events": []string{"expression.created"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("неизвестное событие: код %d, ожидалось 400", rr.Code)
+	}
+
+	// Первый получатель дважды отвечает ошибкой, потом принимает
+	flaky := &webhookReceiver{t: t, statuses: []int{500, 503}}
+	flakySrv := httptest.NewServer(flaky)
+	t.Cleanup(flakySrv.Close)
+	hook := createWebhook(t, r, user.Token, flakySrv.URL, nil)
+	flaky.secret = hook.Secret
+
+	// Второй всегда отвечает ошибкой и подписан только на failed
+	broken := &webhookReceiver{t: t, statuses: []int{500, 500, 500}}
+	brokenSrv := httptest.NewServer(broken)
+	t.Cleanup(brokenSrv.Close)
+	deadHook := createWebhook(t, r, user.Token, brokenSrv.URL, []string{api.EventExpressionFailed})
+	broken.secret = deadHook.Secret
+
+	computeExpression(t, r, h, user.Token, "2+3", 5)
+	computeExpression(t, r, h, user.Token, "1/0", math.Inf(1))
+
+	for i := 0; i < 5; i++ {
+		h.DeliverWebhooks(context.Background())
+	}
+
+	// Доставки идут параллельно, порядок не гарантирован
+	sort.Strings(flaky.events)
+	if len(flaky.events) != 2 || flaky.events[0] != api.EventExpressionCompleted || flaky.events[1] != api.EventExpressionFailed {
+		t.Errorf("доставленные события: %v", flaky.events)
+	}
+	var page deliveriesPage
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", user.Token, nil), &page)
+	attempts := 0
+	for _, d := range page.Deliveries {
+		if d.Status != api.DeliveryDelivered {
+			t.Errorf("доставка %s: статус %s", d.ID, d.Status)
+		}
+		attempts += d.Attempts
+	}
+	if len(page.Deliveries) != 2 || attempts != 4 {
+		t.Errorf("журнал доставки: %d записей, %d попыток", len(page.Deliveries), attempts)
+	}
+
+	// После WebhookMaxAttempts неудач доставка попадает в dead
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/webhooks/"+deadHook.ID+"/deliveries?status=dead", user.Token, nil), &page)
+	if len(page.Deliveries) != 1 || page.Deliveries[0].Attempts != 3 || page.Deliveries[0].LastStatus != 500 {
+		t.Fatalf("dead letter: %+v", page.Deliveries)
+	}
+
+	// Повторная отправка из dead letter
+	rr := doJSON(t, r, "POST", "/api/v1/webhooks/"+deadHook.ID+"/deliveries/"+page.Deliveries[0].ID+"/redeliver", user.Token, nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: код %d", rr.Code)
+	}
+	h.DeliverWebhooks(context.Background())
+	if len(broken.events) != 1 || broken.events[0] != api.EventExpressionFailed {
+		t.Errorf("после redeliver: %v", broken.events)
+	}
+}
+
+func TestWebhookOwnership(t *testing.T) {
+	fastWebhooks(t)
+	r, _, _ := newTestServer(t)
+	owner := registerAndLogin(t, r, "ivan", "ivan's long password")
+	other := registerAndLogin(t, r, "petr", "petr's long password")
+	hook := createWebhook(t, r, owner.Token, "https://billing.example.com/hook", nil)
+
+	var list struct {
+		Webhooks []map[string]interface{} `json:"webhooks"`
+	}
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/webhooks", owner.Token, nil), &list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0]["secret"] != nil {
+		t.Errorf("список вебхуков: %v", list.Webhooks)
+	}
+	if rr := doJSON(t, r, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", other.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("чужой журнал: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "DELETE", "/api/v1/webhooks/"+hook.ID, other.Token, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("удаление чужого: код %d, ожидалось 404", rr.Code)
+	}
+	if rr := doJSON(t, r, "DELETE", "/api/v1/webhooks/"+hook.ID, owner.Token, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("удаление: код %d", rr.Code)
+	}
+}
+
+func TestWebhookBackoffSchedule(t *testing.T) {
+	fastWebhooks(t)
+	r, _, h := newTestServer(t)
+	api.WebhookBackoff = time.Hour
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+	rcv := &webhookReceiver{t: t, statuses: []int{500}}
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+	hook := createWebhook(t, r, user.Token, srv.URL, nil)
+	rcv.secret = hook.Secret
+
+	computeExpression(t, r, h, user.Token, "2+3", 5)
+	if n := h.DeliverWebhooks(context.Background()); n != 1 {
+		t.Fatalf("первая попытка: %d доставок", n)
+	}
+	// Следующая попытка отложена, раньше срока доставка не повторяется
+	if n := h.DeliverWebhooks(context.Background()); n != 0 {
+		t.Errorf("повтор раньше срока: %d доставок", n)
+	}
+	var page deliveriesPage
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", user.Token, nil), &page)
+	d := page.Deliveries[0]
+	if d.Status != api.DeliveryPending || d.NextAttemptAt == nil || time.Until(*d.NextAttemptAt) < 50*time.Minute {
+		t.Errorf("отложенная попытка: %+v", d)
+	}
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	fastWebhooks(t)
+	r, _, h := newTestServer(t)
+	api.WebhookAllowPrivate = false
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	for _, u := range []string{"http://127.0.0.1/hook", "http://10.0.0.5/hook", "http://[::1]/hook",
+		"http://169.254.169.254/latest", "http://0.0.0.0/hook"} {
+		rr := doJSON(t, r, "POST", "/api/v1/webhooks", user.Token, map[string]interface{}{"url": u})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: код %d, ожидалось 400", u, rr.Code)
+		}
+	}
+
+	// Имя разрешается во внутренний адрес только при соединении
+	rcv := &webhookReceiver{t: t}
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	hook := createWebhook(t, r, user.Token, "http://localhost:"+port+"/hook", nil)
+	rcv.secret = hook.Secret
+
+	computeExpression(t, r, h, user.Token, "2+3", 5)
+	h.DeliverWebhooks(context.Background())
+	if len(rcv.events) != 0 {
+		t.Errorf("доставлено на loopback: %v", rcv.events)
+	}
+	var page deliveriesPage
+	decodeJSON(t, doJSON(t, r, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", user.Token, nil), &page)
+	if len(page.Deliveries) != 1 || page.Deliveries[0].Status == api.DeliveryDelivered ||
+		!strings.Contains(page.Deliveries[0].LastError, "not public") {
+		t.Errorf("доставка на loopback: %+v", page.Deliveries)
+	}
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"calculator/internal/api"
+	"calculator/internal/apierror"
+	"calculator/internal/models"
+	"golang.org/x/net/websocket"
+)
+
+type wsReply struct {
+	Type         string             `json:"type"`
+	ID           string             `json:"id"`
+	ExpressionID string             `json:"expression_id"`
+	Expression   *models.Expression `json:"expression"`
+	Error        *apierror.Error    `json:"error"`
+}
+
+func dialWS(t *testing.T, srvURL string, protocols []string, header http.Header) (*websocket.Conn, error) {
+	t.Helper()
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(srvURL, "http")+"/api/v1/ws", srvURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = protocols
+	for k, v := range header {
+		config.Header[k] = v
+	}
+	return websocket.DialConfig(config)
+}
+
+func receiveWS(t *testing.T, ws *websocket.Conn) wsReply {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply wsReply
+	if err := websocket.JSON.Receive(ws, &reply); err != nil {
+		t.Fatalf("чтение из WebSocket: %v", err)
+	}
+	return reply
+}
+
+func TestWebSocketCalculate(t *testing.T) {
+	r, _, h := newTestServer(t)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	user := registerAndLogin(t, r, "ivan", "ivan's long password")
+
+	if _, err := dialWS(t, srv.URL, []string{api.WSProtocol}, nil); err == nil {
+		t.Fatal("соединение без токена принято")
+	}
+	// Токен подпротоколом, как из браузера; сервер возвращает только свой подпротокол
+	ws, err := dialWS(t, srv.URL, []string{api.WSProtocol, "bearer." + user.Token}, nil)
+	if err != nil {
+		t.Fatalf("подключение: %v", err)
+	}
+	defer ws.Close()
+	if got := ws.Config().Protocol; len(got) != 1 || got[0] != api.WSProtocol {
+		t.Errorf("подпротокол ответа: %v", got)
+	}
+
+	// Несколько выражений одновременно
+	for _, m := range []map[string]string{
+		{"type": "calculate", "id": "a", "expression": "2+3"},
+		{"type": "calculate", "id": "b", "expression": "4*5"},
+		{"type": "subscribe", "id": "c"},
+		{"type": "calculate", "id": "a", "expression": "1+1"},
+		{"type": "calculate", "id": "d", "expression": "2+"},
+	} {
+		if err := websocket.JSON.Send(ws, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := map[string]string{}
+	for _, want := range []struct {
+		typ, id string
+		code    apierror.Code
+	}{{"ack", "a", ""}, {"ack", "b", ""}, {"error", "c", apierror.InvalidParameter}, {"error", "a", apierror.InvalidParameter}, {"error", "d", apierror.UnexpectedEnd}} {
+		reply := receiveWS(t, ws)
+		if reply.Type != want.typ || reply.ID != want.id {
+			t.Fatalf("ожидалось %s для %s: %+v", want.typ, want.id, reply)
+		}
+		// Ошибки — в едином формате, как в HTTP ответах
+		if want.code != "" && (reply.Error == nil || reply.Error.Code != want.code || reply.Error.Message != apierror.Message(want.code, "ru")) {
+			t.Errorf("ошибка для %s: %+v", want.id, reply.Error)
+		}
+		if want.id == "d" && reply.Error != nil && reply.Error.Details["position"] != float64(2) {
+			t.Errorf("позиция ошибки разбора: %+v", reply.Error.Details)
+		}
+		if reply.Type == "ack" {
+			ids[reply.ExpressionID] = reply.ID
+		}
+	}
+
+	// Агент присылает результаты в обратном порядке
+	first, _ := h.GetTaskForAgent("agent-1")
+	second, _ := h.GetTaskForAgent("agent-1")
+	if err := h.SubmitAgentResult("agent-1", second.Id, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SubmitAgentResult("agent-1", first.Id, 5); err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]float64{"a": 5, "b": 20}
+	for i := 0; i < 2; i++ {
+		if progress := receiveWS(t, ws); progress.Type != "progress" {
+			t.Fatalf("ожидался progress: %+v", progress)
+		}
+		result := receiveWS(t, ws)
+		if result.Type != "result" || ids[result.ExpressionID] != result.ID || result.Expression == nil ||
+			result.Expression.Result == nil || *result.Expression.Result != results[result.ID] {
+			t.Errorf("неверный result: %+v", result)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package tlsconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DevCertsOptions — параметры генерации сертификатов для разработки
+type DevCertsOptions struct {
+	Dir      string        // каталог для файлов
+	Hosts    []string      // имена и IP адреса сервера (SAN)
+	Agents   []string      // имена агентов, для каждого выпускается клиентский сертификат
+	Validity time.Duration // срок действия сертификатов
+}
+
+// DevCertsFiles — пути к созданным файлам
+type DevCertsFiles struct {
+	CA, CAKey         string
+	Server, ServerKey string
+	Agents            map[string][2]string // имя агента → сертификат, ключ
+}
+
+// GenerateDevCerts создает локальный CA и подписанные им сертификаты
+// сервера и агентов. Только для тестовых окружений: ключ CA лежит
+// рядом с остальными файлами.
+func GenerateDevCerts(opts DevCertsOptions) (*DevCertsFiles, error) {
+	if opts.Validity <= 0 {
+		opts.Validity = 365 * 24 * time.Hour
+	}
+	if len(opts.Hosts) == 0 {
+		opts.Hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("tlsconfig: create dir: %w", err)
+	}
+	files := &DevCertsFiles{
+		CA:        filepath.Join(opts.Dir, "ca.pem"),
+		CAKey:     filepath.Join(opts.Dir, "ca-key.pem"),
+		Server:    filepath.Join(opts.Dir, "server.pem"),
+		ServerKey: filepath.Join(opts.Dir, "server-key.pem"),
+		Agents:    make(map[string][2]string),
+	}
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "calculator dev CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(opts.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caCert, err := issue(caTemplate, caTemplate, caKey, caKey, files.CA, files.CAKey)
+	if err != nil {
+		return nil, err
+	}
+
+	serverTemplate := leafTemplate("calculator orchestrator", now, opts.Validity, x509.ExtKeyUsageServerAuth)
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, h)
+		}
+	}
+	if _, err := issueLeaf(serverTemplate, caCert, caKey, files.Server, files.ServerKey); err != nil {
+		return nil, err
+	}
+
+	// CommonName клиентского сертификата — имя агента для оркестратора
+	for _, name := range opts.Agents {
+		cert := filepath.Join(opts.Dir, "agent-"+name+".pem")
+		key := filepath.Join(opts.Dir, "agent-"+name+"-key.pem")
+		if _, err := issueLeaf(leafTemplate(name, now, opts.Validity, x509.ExtKeyUsageClientAuth), caCert, caKey, cert, key); err != nil {
+			return nil, err
+		}
+		files.Agents[name] = [2]string{cert, key}
+	}
+	return files, nil
+}
+
+func leafTemplate(cn string, now time.Time, validity time.Duration, usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+}
+
+func issueLeaf(template, ca *x509.Certificate, caKey crypto.Signer, certPath, keyPath string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return issue(template, ca, key, caKey, certPath, keyPath)
+}
+
+// issue подписывает сертификат и записывает его и ключ в PEM файлы
+func issue(template, parent *x509.Certificate, key *ecdsa.PrivateKey, signer crypto.Signer, certPath, keyPath string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: create certificate %s: %w", template.Subject.CommonName, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, err
+	}
+	if err := writePEM(keyPath, "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("tlsconfig: write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package tlsconfig собирает *tls.Config для HTTP API и gRPC канала
+// агентов из файлов сертификатов, включая взаимную аутентификацию (mTLS).
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ServerConfig — настройки TLS слушателя
+type ServerConfig struct {
+	CertFile     string // сертификат сервера (PEM, можно с цепочкой)
+	KeyFile      string // ключ сервера
+	ClientCAFile string // CA клиентских сертификатов; если задан, клиент обязан предъявить сертификат
+	// С ClientCAFile: сертификат необязателен, но предъявленный проверяется.
+	// Так HTTP API принимает и браузеры, и агентов с сертификатом.
+	ClientCertOptional bool
+	MinVersion         string // "1.2" или "1.3"
+}
+
+// Enabled — задан ли сертификат, то есть нужно ли включать TLS
+func (c ServerConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ClientConfig — настройки TLS подключения к серверу
+type ClientConfig struct {
+	CAFile     string // CA, которым подписан сертификат сервера; пусто — системные
+	CertFile   string // клиентский сертификат для mTLS
+	KeyFile    string // ключ клиентского сертификата
+	ServerName string // имя сервера для проверки сертификата, если отличается от адреса
+	MinVersion string
+}
+
+// ParseMinVersion переводит "1.2"/"1.3" в константу tls. Пусто — TLS 1.2.
+func ParseMinVersion(v string) (uint16, error) {
+	switch strings.TrimSpace(v) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("tlsconfig: unsupported minimum TLS version %q (use 1.2 or 1.3)", v)
+	}
+}
+
+// loadCertPool читает один или несколько сертификатов CA из PEM файла
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tlsconfig: no certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// Server собирает конфигурацию TLS сервера
+func Server(cfg ServerConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tlsconfig: certificate and key files are required")
+	}
+	minVersion, err := ParseMinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: load server certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientCertOptional {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return conf, nil
+}
+
+// Client собирает конфигурацию TLS клиента
+func Client(cfg ClientConfig) (*tls.Config, error) {
+	minVersion, err := ParseMinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: cfg.ServerName,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconfig: load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
@@ -38,6 +38,12 @@ echo Интеграционные тесты: internal/tests
 echo ===============================
 go test ./internal/tests -v
 
+echo.
+echo ==========================================
+echo Поиск с полнотекстовым индексом (sqlite_fts5)
+echo ==========================================
+go test -tags sqlite_fts5 ./internal/tests -run Search -v
+
 echo.
 echo ======================================
 echo Полное покрытие всех тестов проекта